@@ -14,7 +14,7 @@
 
 repo_root = $(shell git rev-parse --show-toplevel)
 
-lint: lint-all
+lint: lint-all validate-config-schema
 
 fmt: format-go tidy-go
 
@@ -22,7 +22,7 @@ test:
 	@go test -race ./...
 	@(cd tools/prowgen; go test -race ./...)
 
-gen: generate-config fmt mirror-licenses
+gen: generate-config generate-config-schema fmt mirror-licenses
 
 gen-check: gen check-clean-repo
 
@@ -33,6 +33,14 @@ generate-config:
 	@go run tools/prowtrans/cmd/prowtrans/main.go --configs=./prow/config/istio-private_jobs --input=./prow/config/jobs
 	@go run tools/prowtrans/cmd/prowtrans/main.go --configs=./prow/config/experimental --input=./prow/config/jobs
 
+generate-config-schema:
+	@mkdir -p prow/config/schema
+	@(cd tools/prowgen/cmd/prowgen; go run main.go schema jobs > $(repo_root)/prow/config/schema/jobs.schema.json)
+	@(cd tools/prowgen/cmd/prowgen; go run main.go schema base > $(repo_root)/prow/config/schema/base.schema.json)
+
+validate-config-schema:
+	@(cd tools/prowgen/cmd/prowgen; go run main.go --input-dir=$(repo_root)/prow/config/jobs --schema-dir=$(repo_root)/prow/config/schema validate-schema)
+
 diff-config:
 	@(cd tools/prowgen/cmd/prowgen; GOARCH=$(GOARCH) GOOS=$(GOOS) go run main.go --input-dir=$(repo_root)/prow/config/jobs --output-dir=$(repo_root)/prow/cluster/jobs diff)
 
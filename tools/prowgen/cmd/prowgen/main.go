@@ -15,34 +15,40 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
-	"path"
 	"path/filepath"
-	"regexp"
+	"runtime"
+	"sort"
+	"sync"
 
 	"github.com/hashicorp/go-multierror"
 	shell "github.com/kballard/go-shellquote"
+	"k8s.io/apimachinery/pkg/util/sets"
 	k8sProwConfig "k8s.io/test-infra/prow/config"
-	"sigs.k8s.io/yaml"
 
 	"istio.io/test-infra/tools/prowgen/pkg"
 	"istio.io/test-infra/tools/prowgen/pkg/spec"
 )
 
 var (
-	// regex to match the test image tags.
-	tagRegex = regexp.MustCompile(`^(.+):(.+)-([0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}-[0-9]{2}-[0-9]{2})$`)
-
 	inputDir            = flag.String("input-dir", "./prow/config/jobs", "directory of input jobs")
 	outputDir           = flag.String("output-dir", "./prow/cluster/jobs", "directory of output jobs")
 	preprocessCommand   = flag.String("pre-process-command", "", "command to run to preprocess the meta config files")
 	postprocessCommand  = flag.String("post-process-command", "", "command to run to postprocess the generated config files")
 	longJobNamesAllowed = flag.Bool("allow-long-job-names", false, "allow job names that are longer than 63 characters")
+	parallelism         = flag.Int("parallelism", runtime.NumCPU(), "maximum number of meta config files to process in parallel")
+	format              = flag.String("format", "text", "output format of the validate operation, either text or json")
+	testgridOutput      = flag.String("testgrid-output", "", "file to write the generated TestGrid config to, no TestGrid config will be generated if empty")
+	testgridConfig      = flag.String("testgrid-config", "", "TestGrid config file to remove the dashboards of the retired release branch from by the retire operation")
+	imageTagger         = flag.String("image-tagger", pkg.TaggerRegistry, "how the branch operation tags the images, one of registry, dry-run or noop")
+	apply               = flag.Bool("apply", false, "apply the plan of the branch, retire and promote operations, which is only printed otherwise")
+	schemaDir           = flag.String("schema-dir", "./prow/config/schema", "directory of the JSON Schemas the validate-schema operation checks the meta config files against")
 )
 
 func main() {
@@ -50,186 +56,592 @@ func main() {
 
 	// TODO: deserves a better CLI...
 	if len(flag.Args()) < 1 {
-		panic("must provide one of write, print, check, branch")
-	} else if flag.Arg(0) == "branch" {
+		panic("must provide one of write, print, check, diff, validate, validate-schema, schema, graph, explain, load, import, branch, cut, retire, promote")
+	} else if flag.Arg(0) == "branch" || flag.Arg(0) == "cut" || flag.Arg(0) == "retire" {
 		if len(flag.Args()) != 2 {
 			panic("must specify branch name")
 		}
+	} else if flag.Arg(0) == "explain" {
+		if len(flag.Args()) != 2 {
+			panic("must specify job name")
+		}
+	} else if flag.Arg(0) == "import" {
+		if len(flag.Args()) != 2 {
+			panic("must specify the Prow job config file")
+		}
+	} else if flag.Arg(0) == "promote" {
+		if len(flag.Args()) != 3 {
+			panic("must specify branch name and image tag")
+		}
+	} else if flag.Arg(0) == "schema" {
+		if len(flag.Args()) > 2 {
+			panic("too many arguments")
+		}
 	} else if len(flag.Args()) != 1 {
 		panic("too many arguments")
 	}
 
+	if flag.Arg(0) == "schema" {
+		printSchema(flag.Arg(1))
+		return
+	}
+
 	var bc spec.BaseConfig
+	var readErr error
 	if _, err := os.Stat(filepath.Join(*inputDir, ".base.yaml")); !os.IsNotExist(err) {
-		bc = pkg.ReadBase(nil, filepath.Join(*inputDir, ".base.yaml"))
+		bc, readErr = pkg.ReadBase(nil, filepath.Join(*inputDir, ".base.yaml"))
+	}
+	files, err := listMetaFiles(bc)
+	if err != nil {
+		readErr = multierror.Append(readErr, err)
+	}
+	layout, err := pkg.NewOutputLayout(bc.OutputLayout)
+	if err != nil {
+		readErr = multierror.Append(readErr, &pkg.ValidationError{File: filepath.Join(*inputDir, ".base.yaml"), Message: err.Error()})
 	}
 
-	if os.Args[1] == "branch" {
-		if err := filepath.WalkDir(*inputDir, func(path string, d os.DirEntry, err error) error {
-			if !d.IsDir() {
-				return nil
+	if flag.Arg(0) == "validate" {
+		cachedOutput, refs, err := generate(files)
+		if err == nil && layout != nil {
+			_, _, err = layoutOutputs(layout, cachedOutput, refs)
+		}
+		if readErr != nil {
+			err = multierror.Append(readErr, err)
+		}
+		if reportValidationErrors(err) {
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.Arg(0) == "validate-schema" {
+		if reportValidationErrors(validateSchemas(files, readErr)) {
+			os.Exit(1)
+		}
+		return
+	}
+	if readErr != nil {
+		log.Fatalf("Reading the meta config files failed: %v", readErr)
+	}
+
+	switch flag.Arg(0) {
+	case "branch", "cut":
+		tagger, err := pkg.NewImageTagger(*imageTagger)
+		if err != nil {
+			log.Fatal(err)
+		}
+		plan := &pkg.BranchPlan{Release: flag.Arg(1), Dir: *inputDir}
+		for _, f := range files {
+			jobs, err := f.cli.ReadJobsConfig(f.path)
+			if err != nil {
+				log.Fatal(err)
 			}
+			plan.AddFile(f.path, jobs)
+		}
+		if !printPlan(plan) {
+			return
+		}
+		if err := plan.Apply(tagger); err != nil {
+			log.Fatal(err)
+		}
+		if t, ok := tagger.(*pkg.DryRunTagger); ok {
+			log.Printf("Dry run, the %d image tags were not added and the %d files were not written", len(t.Tags), len(plan.Files))
+		}
+	case "graph":
+		configs := map[string]spec.JobsConfig{}
+		for _, f := range files {
+			jobs, err := f.cli.ReadJobsConfig(f.path)
 			if err != nil {
 				log.Fatal(err)
 			}
-			baseConfig := bc
-			if _, err := os.Stat(filepath.Join(path, ".base.yaml")); !os.IsNotExist(err) {
-				baseConfig = pkg.ReadBase(&baseConfig, filepath.Join(path, ".base.yaml"))
+			name, err := filepath.Rel(*inputDir, f.path)
+			if err != nil {
+				log.Fatal(err)
 			}
-			cli := pkg.Client{BaseConfig: baseConfig, LongJobNamesAllowed: *longJobNamesAllowed}
-
-			files, _ := ioutil.ReadDir(path)
-			for _, file := range files {
-				if file.IsDir() {
-					continue
+			configs[name] = jobs
+		}
+		fmt.Print(pkg.NeedsGraph(configs))
+	case "explain":
+		found := false
+		for _, f := range files {
+			explanations, err := f.cli.Explain(f.baseFiles, f.path, flag.Arg(1))
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, e := range explanations {
+				if found {
+					fmt.Println()
 				}
+				fmt.Print(e)
+				found = true
+			}
+		}
+		if !found {
+			log.Fatalf("No job or Prow job named %q", flag.Arg(1))
+		}
+	case "load":
+		cachedOutput, refs, err := generate(files)
+		if err != nil {
+			log.Fatal(err)
+		}
+		periodics := make([]k8sProwConfig.Periodic, 0)
+		for _, r := range refs {
+			periodics = append(periodics, cachedOutput[r].Periodics...)
+		}
+		load, err := pkg.PeriodicLoad(periodics)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Periodic load of %d jobs, averaged over a week:\n", len(periodics))
+		fmt.Print(pkg.FormatPeriodicLoad(load))
+	case "import":
+		jobs, err := pkg.ReadProwJobConfig(flag.Arg(1))
+		if err != nil {
+			log.Fatal(err)
+		}
+		cli := &pkg.Client{BaseConfig: bc, LongJobNamesAllowed: *longJobNamesAllowed}
+		imported, err := cli.Import(jobs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, f := range imported {
+			path := filepath.Join(*inputDir, f.Path)
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				log.Fatalf("Cannot import the jobs to %s, which already exists", path)
+			}
+		}
+		// The files are written even if they do not round trip, so that the
+		// differences can be fixed in them by hand.
+		failed := 0
+		for _, f := range imported {
+			path := filepath.Join(*inputDir, f.Path)
+			fmt.Printf("Import %d jobs to %s\n", len(f.JobsConfig.Jobs), path)
+			if err := pkg.WriteJobsConfig(f.JobsConfig, path); err != nil {
+				log.Fatal(err)
+			}
+			if len(f.Diffs) != 0 {
+				fmt.Printf("--- %s\n%s", path, pkg.FormatDiffs(f.Diffs))
+				failed++
+			}
+		}
+		if failed != 0 {
+			log.Fatalf("The Prow jobs generated from %d of the imported files differ from the original ones", failed)
+		}
+	case "retire":
+		plan := &pkg.RetirePlan{Release: flag.Arg(1), OutputFiles: func(org, repo, branch string) []string {
+			return branchOutputFiles(layout, org, repo, branch)
+		}}
+		for _, f := range files {
+			if err := plan.AddFile(f.cli, f.path); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if !printPlan(plan) {
+			return
+		}
+		if err := plan.Apply(*testgridConfig); err != nil {
+			log.Fatal(err)
+		}
+	case "promote":
+		plan := &pkg.PromotePlan{Release: flag.Arg(1), Tag: flag.Arg(2)}
+		for _, f := range files {
+			if err := plan.AddFile(f.path); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if !printPlan(plan) {
+			return
+		}
+		if err := plan.Apply(); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		if *preprocessCommand != "" {
+			if err := runProcessCommand(*preprocessCommand); err != nil {
+				log.Fatalf("Error running preprocess command %q: %v", *preprocessCommand, err)
+			}
+		}
 
-				if (filepath.Ext(file.Name()) != ".yaml" && filepath.Ext(file.Name()) != ".yml") ||
-					file.Name() == ".base.yaml" {
-					log.Println("skipping non-yaml file: ", file.Name())
-					continue
-				}
+		cachedOutput, refs, err := generate(files)
+		if err != nil {
+			log.Fatal(err)
+		}
+		paths, outputs, err := layoutOutputs(layout, cachedOutput, refs)
+		if err != nil {
+			log.Fatal(err)
+		}
 
-				src := filepath.Join(path, file.Name())
-				jobs := cli.ReadJobsConfig(src)
-				jobs.Jobs = pkg.FilterReleaseBranchingJobs(jobs.Jobs)
-
-				if jobs.SupportReleaseBranching {
-					match := tagRegex.FindStringSubmatch(jobs.Image)
-					branch := "release-" + flag.Arg(1)
-					if len(match) == 4 {
-						// HACK: replacing the branch name in the image tag and
-						// adding it as a new tag.
-						// For example, if the test image in the current Prow job
-						// config is
-						// `gcr.io/istio-testing/build-tools:release-1.10-2021-08-09T16-46-08`,
-						// and the Prow job config for release-1.11 branch is
-						// supposed to be generated, the image will be added a
-						// new `release-1.11-2021-08-09T16-46-08` tag.
-						// This is only needed for creating Prow jobs for a new
-						// release branch for the first time, and the image tag
-						// will be overwritten by Automator the next time the
-						// image for the new branch is updated.
-						newImage := fmt.Sprintf("%s:%s-%s", match[1], branch, match[3])
-						if err := exec.Command("gcloud", "container", "images", "add-tag", match[0], newImage).Run(); err != nil {
-							log.Fatalf("Unable to add image tag %q: %v", newImage, err)
-						} else {
-							jobs.Image = newImage
-						}
-					}
-					jobs.Branches = []string{branch}
-					jobs.SupportReleaseBranching = false
-
-					name := file.Name()
-					ext := filepath.Ext(name)
-					name = name[:len(name)-len(ext)] + "-" + flag.Arg(1) + ext
-
-					dst := filepath.Join(*inputDir, name)
-					bytes, err := yaml.Marshal(jobs)
-					if err != nil {
-						log.Fatalf("Error marshaling jobs config: %v", err)
-					}
-
-					// Writes the job yaml
-					if err := ioutil.WriteFile(dst, bytes, 0o644); err != nil {
-						log.Fatalf("Error writing branches config: %v", err)
-					}
+		// Writing and checking the files are independent from each other, so
+		// they can be done in parallel, while the other operations print to
+		// stdout and must keep the order.
+		errs := make([]error, len(paths))
+		switch flag.Arg(0) {
+		case "write":
+			runParallel(len(paths), func(i int) {
+				errs[i] = pkg.Write(outputs[paths[i]], paths[i], bc.AutogenHeader)
+			})
+		case "check":
+			runParallel(len(paths), func(i int) {
+				errs[i] = pkg.Check(outputs[paths[i]], paths[i], bc.AutogenHeader)
+			})
+		case "diff":
+			for i, fname := range paths {
+				diffs, e := pkg.Diff(outputs[fname], fname)
+				if e != nil {
+					errs[i] = e
+				} else if len(diffs) != 0 {
+					fmt.Printf("--- %s\n%s", fname, pkg.FormatDiffs(diffs))
 				}
 			}
+		case "print":
+			for _, fname := range paths {
+				pkg.Print(outputs[fname])
+			}
+		}
+		err = nil
+		for _, e := range errs {
+			if e != nil {
+				err = multierror.Append(err, e)
+			}
+		}
 
-			return nil
-		}); err != nil {
-			log.Fatalf("Walking through the meta config files failed: %v", err)
+		if *testgridOutput != "" {
+			jobConfigs := make([]k8sProwConfig.JobConfig, 0, len(refs))
+			for _, r := range refs {
+				jobConfigs = append(jobConfigs, cachedOutput[r])
+			}
+			if e := processTestgridConfig(jobConfigs, bc); e != nil {
+				err = multierror.Append(err, e)
+			}
 		}
-	} else {
-		if *preprocessCommand != "" {
-			if err := runProcessCommand(*preprocessCommand); err != nil {
-				log.Fatalf("Error running preprocess command %q: %v", *preprocessCommand, err)
+
+		// The post-process command works on the whole output directory, so it
+		// only needs to run once after all the files are written.
+		if flag.Arg(0) == "write" && *postprocessCommand != "" {
+			if e := runProcessCommand(*postprocessCommand); e != nil {
+				err = multierror.Append(err, e)
 			}
 		}
 
-		type ref struct {
-			org    string
-			repo   string
-			branch string
+		if err != nil {
+			log.Fatalf("Get errors for the %q operation:\n%v", flag.Arg(0), err)
+		}
+	}
+}
+
+type ref struct {
+	org    string
+	repo   string
+	branch string
+}
+
+// metaFile is a meta config file and the client for the base config of the
+// folder it's in.
+type metaFile struct {
+	path string
+	cli  *pkg.Client
+	// baseFiles are the .base.yaml files the base config is read from, in
+	// order.
+	baseFiles []string
+}
+
+// listMetaFiles walks through the input directory and returns all the meta
+// config files, in lexical order. The folders with an invalid base config are
+// skipped and their errors are returned along with the other files. The preset
+// libraries imported by the base configs are not meta config files, so they
+// are skipped as well.
+func listMetaFiles(bc spec.BaseConfig) ([]metaFile, error) {
+	res := make([]metaFile, 0)
+	imported := sets.NewString()
+	var readErr error
+	err := filepath.WalkDir(*inputDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
 		}
-		// Store the job config generated from all meta-config files in a cache map, and combine the
-		// job configs before we generate the final config files.
-		// In this way we can have multiple meta-config files for the same org/repo:branch
-		cachedOutput := map[ref]k8sProwConfig.JobConfig{}
-		if err := filepath.WalkDir(*inputDir, func(path string, d os.DirEntry, err error) error {
-			if !d.IsDir() {
+
+		baseConfig := bc
+		baseFiles := pkg.BaseFiles(*inputDir, path)
+		// The base config of the input directory is already read.
+		if _, err := os.Stat(filepath.Join(path, ".base.yaml")); !os.IsNotExist(err) && path != *inputDir {
+			if baseConfig, err = pkg.ReadBase(&baseConfig, filepath.Join(path, ".base.yaml")); err != nil {
+				readErr = multierror.Append(readErr, err)
 				return nil
 			}
-			if err != nil {
-				log.Fatal(err)
+		}
+		for _, imp := range baseConfig.Imports {
+			if abs, err := filepath.Abs(imp); err == nil {
+				imported.Insert(abs)
 			}
+		}
+		// The policies only gate the operations that update or verify the
+		// generated files, so that the other ones can still show the jobs.
+		cli := &pkg.Client{
+			BaseConfig:          baseConfig,
+			LongJobNamesAllowed: *longJobNamesAllowed,
+			EnforcePolicies:     sets.NewString("write", "check", "validate").Has(flag.Arg(0)),
+		}
 
-			baseConfig := bc
-			if _, err := os.Stat(filepath.Join(path, ".base.yaml")); !os.IsNotExist(err) {
-				baseConfig = pkg.ReadBase(&baseConfig, filepath.Join(path, ".base.yaml"))
+		files, _ := ioutil.ReadDir(path)
+		for _, file := range files {
+			if file.IsDir() {
+				continue
 			}
-			cli := pkg.Client{BaseConfig: baseConfig, LongJobNamesAllowed: *longJobNamesAllowed}
 
-			files, _ := ioutil.ReadDir(path)
-			for _, file := range files {
-				if file.IsDir() {
-					continue
-				}
-
-				if (filepath.Ext(file.Name()) != ".yaml" && filepath.Ext(file.Name()) != ".yml") ||
-					file.Name() == ".base.yaml" {
-					log.Println("skipping non-yaml file: ", file.Name())
-					continue
-				}
+			if (filepath.Ext(file.Name()) != ".yaml" && filepath.Ext(file.Name()) != ".yml") ||
+				file.Name() == ".base.yaml" {
+				log.Println("skipping non-yaml file: ", file.Name())
+				continue
+			}
+			res = append(res, metaFile{path: filepath.Join(path, file.Name()), cli: cli, baseFiles: baseFiles})
+		}
+		return nil
+	})
+	if err != nil {
+		readErr = multierror.Append(readErr, fmt.Errorf("walking through the meta config files failed: %v", err))
+	}
+	metaFiles := make([]metaFile, 0, len(res))
+	for _, f := range res {
+		if abs, err := filepath.Abs(f.path); err == nil && imported.Has(abs) {
+			log.Println("skipping preset library: ", f.path)
+			continue
+		}
+		metaFiles = append(metaFiles, f)
+	}
+	return metaFiles, readErr
+}
 
-				src := filepath.Join(path, file.Name())
-				jobs := cli.ReadJobsConfig(src)
-				for _, branch := range jobs.Branches {
-					output, err := cli.ConvertJobConfig(file.Name(), jobs, branch)
-					if err != nil {
-						log.Fatal(err)
-					}
-					rf := ref{jobs.Org, jobs.Repo, branch}
-					if _, ok := cachedOutput[rf]; !ok {
-						cachedOutput[rf] = output
-					} else {
-						cachedOutput[rf] = combineJobConfigs(cachedOutput[rf], output,
-							fmt.Sprintf("%s/%s", jobs.Org, jobs.Repo))
-					}
-				}
+// generate converts all the meta config files in parallel, and combines the
+// job configs generated for the same org/repo:branch.
+// In this way we can have multiple meta-config files for the same org/repo:branch
+// The job configs are always combined in the order of the files, so that the
+// result is deterministic. The refs are returned sorted by org, repo and branch.
+func generate(files []metaFile) (map[ref]k8sProwConfig.JobConfig, []ref, error) {
+	type result struct {
+		ref    ref
+		output k8sProwConfig.JobConfig
+	}
+	results := make([][]result, len(files))
+	errs := make([]error, len(files))
+	runParallel(len(files), func(i int) {
+		jobs, err := files[i].cli.ReadJobsConfig(files[i].path)
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		for _, branch := range jobs.Branches {
+			output, err := files[i].cli.ConvertJobConfig(files[i].path, jobs, branch)
+			if err != nil {
+				errs[i] = err
+				return
 			}
-			return nil
-		}); err != nil {
-			log.Fatalf("Walking through the meta config files failed: %v", err)
+			results[i] = append(results[i], result{ref{jobs.Org, jobs.Repo, branch}, output})
+		}
+	})
+
+	var err error
+	cachedOutput := map[ref]k8sProwConfig.JobConfig{}
+	for i := range files {
+		if errs[i] != nil {
+			err = multierror.Append(err, errs[i])
+			continue
+		}
+		for _, res := range results[i] {
+			if _, ok := cachedOutput[res.ref]; !ok {
+				cachedOutput[res.ref] = res.output
+			} else {
+				cachedOutput[res.ref] = pkg.CombineJobConfigs(cachedOutput[res.ref], res.output)
+			}
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refs := make([]ref, 0, len(cachedOutput))
+	for r := range cachedOutput {
+		refs = append(refs, r)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].org != refs[j].org {
+			return refs[i].org < refs[j].org
+		}
+		if refs[i].repo != refs[j].repo {
+			return refs[i].repo < refs[j].repo
 		}
+		return refs[i].branch < refs[j].branch
+	})
+	return cachedOutput, refs, nil
+}
 
-		var err error
-		for r, output := range cachedOutput {
-			fname := outputFileName(r.repo, r.org, r.branch)
-			switch flag.Arg(0) {
-			case "write":
-				if e := pkg.Write(output, fname, bc.AutogenHeader); e != nil {
-					err = multierror.Append(err, e)
-				}
-				if *postprocessCommand != "" {
-					if e := runProcessCommand(*postprocessCommand); e != nil {
-						err = multierror.Append(err, e)
-					}
-				}
-			case "check":
-				if e := pkg.Check(output, fname, bc.AutogenHeader); e != nil {
-					err = multierror.Append(err, e)
-				}
-			case "print":
-				pkg.Print(output)
+// layoutOutputs groups the generated Prow jobs by the output file they are
+// written to with the layout. The jobs of the refs written to the same file
+// are combined in the order of the refs. The paths of the files are returned
+// sorted, and must have unique base names.
+func layoutOutputs(layout pkg.OutputLayout, cachedOutput map[ref]k8sProwConfig.JobConfig,
+	refs []ref) ([]string, map[string]k8sProwConfig.JobConfig, error) {
+	paths := make([]string, 0)
+	outputs := map[string]k8sProwConfig.JobConfig{}
+	for _, r := range refs {
+		files, jobs, err := pkg.LayoutFiles(layout, r.org, r.repo, r.branch, cachedOutput[r])
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, f := range files {
+			fname := filepath.Join(*outputDir, f)
+			if _, ok := outputs[fname]; !ok {
+				paths = append(paths, fname)
+				outputs[fname] = jobs[i]
+			} else {
+				outputs[fname] = pkg.CombineJobConfigs(outputs[fname], jobs[i])
 			}
 		}
+	}
+	sort.Strings(paths)
+	if err := pkg.ValidateOutputBasenames(paths); err != nil {
+		return nil, nil, err
+	}
+	return paths, outputs, nil
+}
 
+// branchOutputFiles returns the output files that only contain the Prow jobs
+// of the org/repo:branch with the layout, i.e. whose path depends on the
+// branch.
+func branchOutputFiles(layout pkg.OutputLayout, org, repo, branch string) []string {
+	res := make([]string, 0)
+	for _, t := range []string{pkg.TypePresubmit, pkg.TypePostsubmit, pkg.TypePeriodic} {
+		p, err := layout(pkg.OutputRef{Org: org, Repo: repo, Branch: branch, Type: t})
 		if err != nil {
-			log.Fatalf("Get errors for the %q operation:\n%v", flag.Arg(0), err)
+			continue
+		}
+		if other, err := layout(pkg.OutputRef{Org: org, Repo: repo, Type: t}); err == nil && other == p {
+			continue
+		}
+		if fname := filepath.Join(*outputDir, p); !sets.NewString(res...).Has(fname) {
+			res = append(res, fname)
+		}
+	}
+	return res
+}
+
+// printPlan prints the plan of an operation for review, and returns whether it
+// should be applied, i.e. if the --apply flag is set.
+func printPlan(plan fmt.Stringer) bool {
+	fmt.Print(plan)
+	if !*apply {
+		log.Print("The plan was not applied, rerun with --apply to apply it")
+		return false
+	}
+	return true
+}
+
+// printSchema prints the JSON Schema of the meta job config files, or of the
+// .base.yaml files if kind is base.
+func printSchema(kind string) {
+	var schema *pkg.JSONSchema
+	switch kind {
+	case "", "jobs":
+		schema = pkg.JobsConfigSchema()
+	case "base":
+		schema = pkg.BaseConfigSchema()
+	default:
+		log.Fatalf("Unknown schema %q, must be one of jobs, base", kind)
+	}
+	bs, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to marshal the schema: %v", err)
+	}
+	fmt.Println(string(bs))
+}
+
+// validateSchemas checks the meta config files and the .base.yaml files they
+// use against the JSON Schemas in --schema-dir, and returns all the violations
+// with the errors reading the files.
+func validateSchemas(files []metaFile, readErr error) error {
+	err := readErr
+	baseFiles := sets.NewString()
+	for _, f := range files {
+		baseFiles.Insert(f.baseFiles...)
+		if e := pkg.ValidateSchema(filepath.Join(*schemaDir, "jobs.schema.json"), f.path); e != nil {
+			err = multierror.Append(err, e)
+		}
+	}
+	for _, f := range baseFiles.List() {
+		if e := pkg.ValidateSchema(filepath.Join(*schemaDir, "base.schema.json"), f); e != nil {
+			err = multierror.Append(err, e)
+		}
+	}
+	return err
+}
+
+// reportValidationErrors prints all the validation errors, without duplicates,
+// in the format given by the --format flag. It returns whether there is any
+// error.
+func reportValidationErrors(err error) bool {
+	errs := make([]*pkg.ValidationError, 0)
+	seen := map[string]bool{}
+	for _, e := range pkg.ValidationErrors(err) {
+		if !seen[e.Error()] {
+			seen[e.Error()] = true
+			errs = append(errs, e)
+		}
+	}
+
+	switch *format {
+	case "json":
+		bs, e := json.MarshalIndent(errs, "", "  ")
+		if e != nil {
+			log.Fatalf("Failed to marshal the validation errors: %v", e)
+		}
+		fmt.Println(string(bs))
+	default:
+		for _, e := range errs {
+			fmt.Println(e.Error())
 		}
 	}
+	return len(errs) != 0
+}
+
+// runParallel calls fn for each index in [0, n) with at most --parallelism
+// concurrent workers.
+func runParallel(n int, fn func(i int)) {
+	workers := *parallelism
+	if workers < 1 {
+		workers = 1
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// processTestgridConfig generates the TestGrid config for all the Prow jobs
+// and applies the current operation to it.
+func processTestgridConfig(jobConfigs []k8sProwConfig.JobConfig, bc spec.BaseConfig) error {
+	testgridConfig, err := pkg.GenerateTestgridConfig(jobConfigs, bc.TestgridConfig.GCSLogBucket)
+	if err != nil {
+		return err
+	}
+	switch flag.Arg(0) {
+	case "write":
+		return pkg.WriteTestgridConfig(testgridConfig, *testgridOutput, bc.AutogenHeader)
+	case "check":
+		return pkg.CheckTestgridConfig(testgridConfig, *testgridOutput, bc.AutogenHeader)
+	}
+	return nil
 }
 
 func runProcessCommand(rawCommand string) error {
@@ -249,24 +661,3 @@ func runProcessCommand(rawCommand string) error {
 
 	return cmd.Run()
 }
-
-func outputFileName(repo string, org string, branch string) string {
-	key := fmt.Sprintf("%s.%s.%s.gen.yaml", org, repo, branch)
-	return path.Join(*outputDir, org, repo, key)
-}
-
-func combineJobConfigs(jc1, jc2 k8sProwConfig.JobConfig, orgRepo string) k8sProwConfig.JobConfig {
-	presubmits := jc1.PresubmitsStatic
-	postsubmits := jc1.PostsubmitsStatic
-	periodics := jc1.Periodics
-
-	presubmits[orgRepo] = append(presubmits[orgRepo], jc2.PresubmitsStatic[orgRepo]...)
-	postsubmits[orgRepo] = append(postsubmits[orgRepo], jc2.PostsubmitsStatic[orgRepo]...)
-	periodics = append(periodics, jc2.Periodics...)
-
-	return k8sProwConfig.JobConfig{
-		PresubmitsStatic:  presubmits,
-		PostsubmitsStatic: postsubmits,
-		Periodics:         periodics,
-	}
-}
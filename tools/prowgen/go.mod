@@ -11,10 +11,13 @@ replace (
 
 require (
 	github.com/google/go-cmp v0.5.6
+	github.com/google/go-containerregistry v0.1.1
 	github.com/hashicorp/go-multierror v1.1.1
 	github.com/imdario/mergo v0.3.12
 	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51
+	github.com/xeipuuv/gojsonschema v1.1.0
 	gopkg.in/robfig/cron.v2 v2.0.0-20150107220207-be2e0b0deed5
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 	k8s.io/api v0.22.2
 	k8s.io/apimachinery v0.22.2
 	k8s.io/test-infra v0.0.0-20220110151312-600d25dbe068
@@ -0,0 +1,138 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"istio.io/test-infra/tools/prowgen/pkg/spec"
+)
+
+// regex to match the test image tags.
+var tagRegex = regexp.MustCompile(`^(.+):(.+)-([0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}-[0-9]{2}-[0-9]{2})$`)
+
+// ImageTag is a new tag to add to an existing image.
+type ImageTag struct {
+	Source string
+	Target string
+}
+
+// BranchFile is a meta config file to create for a new release branch.
+type BranchFile struct {
+	// Source is the meta config file it's created from.
+	Source string
+	Path   string
+	Config spec.JobsConfig
+}
+
+// BranchPlan contains all the changes to create the meta config files of a
+// new release branch, so that they can be reviewed before being applied.
+type BranchPlan struct {
+	// Release is the version of the release branch, e.g. 1.11 for
+	// release-1.11.
+	Release string
+	// Dir is the directory to create the meta config files in.
+	Dir string
+
+	Files []BranchFile
+	Tags  []ImageTag
+}
+
+// AddFile adds the meta config file for the release branch to the plan, if the
+// jobs config supports release branching. The jobs config must have been read
+// from the file.
+func (p *BranchPlan) AddFile(file string, jobsConfig spec.JobsConfig) {
+	if !jobsConfig.SupportReleaseBranching {
+		return
+	}
+	jobsConfig.Jobs = FilterReleaseBranchingJobs(jobsConfig.Jobs)
+
+	branch := "release-" + p.Release
+	if match := tagRegex.FindStringSubmatch(jobsConfig.Image); len(match) == 4 {
+		// HACK: replacing the branch name in the image tag and adding it as a
+		// new tag.
+		// For example, if the test image in the current Prow job config is
+		// `gcr.io/istio-testing/build-tools:release-1.10-2021-08-09T16-46-08`,
+		// and the Prow job config for release-1.11 branch is supposed to be
+		// generated, the image will be added a new
+		// `release-1.11-2021-08-09T16-46-08` tag.
+		// This is only needed for creating Prow jobs for a new release branch
+		// for the first time, and the image tag will be overwritten by
+		// Automator the next time the image for the new branch is updated.
+		newImage := fmt.Sprintf("%s:%s-%s", match[1], branch, match[3])
+		p.addTag(ImageTag{Source: match[0], Target: newImage})
+		jobsConfig.Image = newImage
+	}
+	jobsConfig.Branches = []string{branch}
+	jobsConfig.SupportReleaseBranching = false
+	// The overrides are for the existing branches.
+	jobsConfig.BranchOverrides = nil
+
+	name := filepath.Base(file)
+	ext := filepath.Ext(name)
+	name = name[:len(name)-len(ext)] + "-" + p.Release + ext
+	p.Files = append(p.Files, BranchFile{
+		Source: file,
+		Path:   filepath.Join(p.Dir, name),
+		Config: jobsConfig,
+	})
+}
+
+// addTag adds the image tag to the plan, unless another file already added it.
+func (p *BranchPlan) addTag(tag ImageTag) {
+	for _, t := range p.Tags {
+		if t == tag {
+			return
+		}
+	}
+	p.Tags = append(p.Tags, tag)
+}
+
+// String returns the human readable form of the plan.
+func (p *BranchPlan) String() string {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("Files to create (%d):\n", len(p.Files)))
+	for _, f := range p.Files {
+		sb.WriteString(fmt.Sprintf("  %s (from %s)\n", f.Path, f.Source))
+	}
+	sb.WriteString(fmt.Sprintf("Images to tag (%d):\n", len(p.Tags)))
+	for _, t := range p.Tags {
+		sb.WriteString(fmt.Sprintf("  %s -> %s\n", t.Source, t.Target))
+	}
+	return sb.String()
+}
+
+// Apply adds the image tags with the tagger, and then writes the meta config
+// files. No file is written if any image fails to be tagged, or if the tagger
+// only records the tags, since the files reference the new tags.
+func (p *BranchPlan) Apply(tagger ImageTagger) error {
+	for _, t := range p.Tags {
+		if err := tagger.AddTag(t.Source, t.Target); err != nil {
+			return fmt.Errorf("unable to add image tag %q: %v", t.Target, err)
+		}
+	}
+	if _, ok := tagger.(*DryRunTagger); ok {
+		return nil
+	}
+	for _, f := range p.Files {
+		if err := WriteJobsConfig(f.Config, f.Path); err != nil {
+			return fmt.Errorf("error writing branches config: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"sigs.k8s.io/yaml"
+
+	"istio.io/test-infra/tools/prowgen/pkg/spec"
+)
+
+type failingTagger struct{}
+
+func (failingTagger) AddTag(string, string) error {
+	return errors.New("unauthorized")
+}
+
+func TestBranchPlan(t *testing.T) {
+	image := "gcr.io/istio-testing/build-tools:master-2021-08-09T16-46-08"
+	newImage := "gcr.io/istio-testing/build-tools:release-1.11-2021-08-09T16-46-08"
+	configs := map[string]spec.JobsConfig{
+		"istio.yaml": {
+			SupportReleaseBranching: true,
+			Branches:                []string{"master"},
+			CommonConfig:            spec.CommonConfig{Image: image},
+			Jobs: []spec.Job{
+				{Name: "unit"},
+				{Name: "master-only", DisableReleaseBranching: true},
+			},
+		},
+		"proxy.yaml": {
+			SupportReleaseBranching: true,
+			CommonConfig:            spec.CommonConfig{Image: image},
+		},
+		"release-1.10.yaml": {
+			CommonConfig: spec.CommonConfig{Image: image},
+		},
+	}
+
+	dir := t.TempDir()
+	plan := &BranchPlan{Release: "1.11", Dir: dir}
+	for _, file := range []string{"istio.yaml", "proxy.yaml", "release-1.10.yaml"} {
+		plan.AddFile(filepath.Join("jobs", file), configs[file])
+	}
+
+	wantTags := []ImageTag{{Source: image, Target: newImage}}
+	if diff := cmp.Diff(wantTags, plan.Tags); diff != "" {
+		t.Fatalf("Planned image tags do not match, (-want, +got): \n%s", diff)
+	}
+	wantPlan := "Files to create (2):\n" +
+		"  " + filepath.Join(dir, "istio-1.11.yaml") + " (from jobs/istio.yaml)\n" +
+		"  " + filepath.Join(dir, "proxy-1.11.yaml") + " (from jobs/proxy.yaml)\n" +
+		"Images to tag (1):\n" +
+		"  " + image + " -> " + newImage + "\n"
+	if diff := cmp.Diff(wantPlan, plan.String()); diff != "" {
+		t.Fatalf("Plan does not match, (-want, +got): \n%s", diff)
+	}
+
+	if err := plan.Apply(failingTagger{}); err == nil {
+		t.Fatal("Expected an error when the image cannot be tagged, but did not receive one")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("Expected no file to be written when the image cannot be tagged, got %d", len(files))
+	}
+
+	tagger := &DryRunTagger{}
+	if err := plan.Apply(tagger); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(wantTags, tagger.Tags); diff != "" {
+		t.Fatalf("Added image tags do not match, (-want, +got): \n%s", diff)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("Expected no file to be written by a dry run, got %d", len(files))
+	}
+
+	if err := plan.Apply(NoopTagger{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "istio-1.11.yaml"))
+	if err != nil {
+		t.Fatalf("Failed to read the created file: %v", err)
+	}
+	got := spec.JobsConfig{}
+	if err := yaml.Unmarshal(bs, &got); err != nil {
+		t.Fatalf("Failed to unmarshal the created file: %v", err)
+	}
+	want := spec.JobsConfig{
+		Branches:     []string{"release-1.11"},
+		CommonConfig: spec.CommonConfig{Image: newImage},
+		Jobs:         []spec.Job{{Name: "unit"}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("Created file does not match, (-want, +got): \n%s", diff)
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package decorator
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	VariableBranch = "branch"
+	VariableOrg    = "org"
+	VariableRepo   = "repo"
+)
+
+// expressionFunc evaluates a function call, with the values of its arguments.
+// The value of an argument is nil if it references a variable that is not
+// configured.
+type expressionFunc func(args []*string) (string, error)
+
+var expressionFuncs = map[string]expressionFunc{
+	"upper": stringFunc(1, func(args []string) string {
+		return strings.ToUpper(args[0])
+	}),
+	"lower": stringFunc(1, func(args []string) string {
+		return strings.ToLower(args[0])
+	}),
+	"replace": stringFunc(3, func(args []string) string {
+		return strings.ReplaceAll(args[0], args[1], args[2])
+	}),
+	"trimprefix": stringFunc(2, func(args []string) string {
+		return strings.TrimPrefix(args[0], args[1])
+	}),
+	"trimsuffix": stringFunc(2, func(args []string) string {
+		return strings.TrimSuffix(args[0], args[1])
+	}),
+	"default": func(args []*string) (string, error) {
+		if len(args) != 2 {
+			return "", fmt.Errorf("default takes 2 arguments, got %d", len(args))
+		}
+		if args[0] != nil && *args[0] != "" {
+			return *args[0], nil
+		}
+		if args[1] == nil {
+			return "", errors.New("the fallback value of default is not configured")
+		}
+		return *args[1], nil
+	},
+}
+
+// stringFunc creates a function that takes n arguments, which must all be
+// configured.
+func stringFunc(n int, fn func(args []string) string) expressionFunc {
+	return func(args []*string) (string, error) {
+		if len(args) != n {
+			return "", fmt.Errorf("takes %d arguments, got %d", n, len(args))
+		}
+		vals := make([]string, len(args))
+		for i, arg := range args {
+			if arg == nil {
+				return "", errors.New("argument is not configured")
+			}
+			vals[i] = *arg
+		}
+		return fn(vals), nil
+	}
+}
+
+// expression is a parsed $(...) expression, which is either a variable
+// reference such as $(params.name), or a function call such as
+// $(upper matrix.name).
+type expression struct {
+	raw  string
+	fn   string
+	args []argument
+}
+
+// argument is either a variable reference or a quoted string literal.
+type argument struct {
+	ref     string
+	literal *string
+}
+
+// evalContext contains the values of the variables of a job.
+type evalContext struct {
+	params   map[string]string
+	matrix   map[string]string
+	builtins map[string]string
+}
+
+// findExpressions returns the expressions in the string, in order. The $(...)
+// parts that are not expressions, e.g. shell command substitutions, are
+// skipped.
+func findExpressions(s string) []expression {
+	res := make([]expression, 0)
+	for i := 0; i < len(s); {
+		start := strings.Index(s[i:], "$(")
+		if start == -1 {
+			break
+		}
+		start += i
+		end := closingParen(s, start+2)
+		if end == -1 {
+			break
+		}
+		if exp, ok := parseExpression(s[start : end+1]); ok {
+			res = append(res, exp)
+			i = end + 1
+		} else {
+			i = start + 2
+		}
+	}
+	return res
+}
+
+// closingParen returns the index of the parenthesis closing the expression
+// starting at i, skipping the quoted strings, or -1 if there is none.
+func closingParen(s string, i int) int {
+	inQuote := false
+	for ; i < len(s); i++ {
+		switch {
+		case inQuote && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			inQuote = !inQuote
+		case !inQuote && s[i] == '(':
+			return -1
+		case !inQuote && s[i] == ')':
+			return i
+		}
+	}
+	return -1
+}
+
+// parseExpression parses the $(...) string, and returns false if it's not an
+// expression.
+func parseExpression(raw string) (expression, bool) {
+	tokens, ok := tokenize(raw[2 : len(raw)-1])
+	if !ok || len(tokens) == 0 || tokens[0].literal != nil {
+		return expression{}, false
+	}
+	if len(tokens) == 1 {
+		if !isVariable(tokens[0].ref) {
+			return expression{}, false
+		}
+		return expression{raw: raw, args: tokens}, true
+	}
+	if _, ok := expressionFuncs[tokens[0].ref]; !ok {
+		return expression{}, false
+	}
+	return expression{raw: raw, fn: tokens[0].ref, args: tokens[1:]}, true
+}
+
+func tokenize(s string) ([]argument, bool) {
+	res := make([]argument, 0)
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		if s[0] == '"' {
+			end := 1
+			for ; end < len(s) && s[end] != '"'; end++ {
+				if s[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(s) {
+				return nil, false
+			}
+			lit, err := strconv.Unquote(s[:end+1])
+			if err != nil {
+				return nil, false
+			}
+			res = append(res, argument{literal: &lit})
+			s = s[end+1:]
+			continue
+		}
+		end := strings.IndexAny(s, " \t\"")
+		if end == -1 {
+			end = len(s)
+		}
+		res = append(res, argument{ref: s[:end]})
+		s = s[end:]
+	}
+	return res, true
+}
+
+func isVariable(ref string) bool {
+	switch ref {
+	case VariableBranch, VariableOrg, VariableRepo:
+		return true
+	}
+	return (strings.HasPrefix(ref, paramsPrefix) && len(ref) > len(paramsPrefix)) ||
+		(strings.HasPrefix(ref, matrixPrefix) && len(ref) > len(matrixPrefix))
+}
+
+// matrixDimensions returns the matrix dimensions referenced by the expression.
+func (exp expression) matrixDimensions() []string {
+	res := make([]string, 0)
+	for _, arg := range exp.args {
+		if strings.HasPrefix(arg.ref, matrixPrefix) {
+			res = append(res, strings.TrimPrefix(arg.ref, matrixPrefix))
+		}
+	}
+	return res
+}
+
+// eval evaluates the expression with the values of the variables.
+func (exp expression) eval(ctx evalContext) (string, error) {
+	vals := make([]*string, len(exp.args))
+	for i, arg := range exp.args {
+		if arg.literal != nil {
+			vals[i] = arg.literal
+			continue
+		}
+		if !isVariable(arg.ref) {
+			return "", fmt.Errorf("%s: unknown variable %q", exp.raw, arg.ref)
+		}
+		if v, ok := ctx.lookup(arg.ref); ok {
+			vals[i] = &v
+		}
+	}
+
+	if exp.fn == "" {
+		if vals[0] == nil {
+			return "", ctx.notConfigured(exp.args[0].ref)
+		}
+		return *vals[0], nil
+	}
+	res, err := expressionFuncs[exp.fn](vals)
+	if err != nil {
+		// Report the variables that are not configured, which is the
+		// usual cause of the error.
+		for i, v := range vals {
+			if v == nil && exp.fn != "default" {
+				return "", fmt.Errorf("%s: %v", exp.raw, ctx.notConfigured(exp.args[i].ref))
+			}
+		}
+		return "", fmt.Errorf("%s: %s %v", exp.raw, exp.fn, err)
+	}
+	return res, nil
+}
+
+func (ctx evalContext) lookup(ref string) (string, bool) {
+	var v string
+	var ok bool
+	switch {
+	case strings.HasPrefix(ref, paramsPrefix):
+		v, ok = ctx.params[strings.TrimPrefix(ref, paramsPrefix)]
+	case strings.HasPrefix(ref, matrixPrefix):
+		v, ok = ctx.matrix[strings.TrimPrefix(ref, matrixPrefix)]
+	default:
+		v, ok = ctx.builtins[ref]
+	}
+	return v, ok
+}
+
+func (ctx evalContext) notConfigured(ref string) error {
+	switch {
+	case strings.HasPrefix(ref, paramsPrefix):
+		return fmt.Errorf("param %q not configured in the params map %v", strings.TrimPrefix(ref, paramsPrefix), ctx.params)
+	case strings.HasPrefix(ref, matrixPrefix):
+		return fmt.Errorf("dimension %q not configured in the matrix", strings.TrimPrefix(ref, matrixPrefix))
+	default:
+		return fmt.Errorf("variable %q is not set", ref)
+	}
+}
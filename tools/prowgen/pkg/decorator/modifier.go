@@ -15,7 +15,11 @@
 package decorator
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/go-multierror"
 
 	prowjob "k8s.io/test-infra/prow/apis/prowjobs/v1"
 	"k8s.io/test-infra/prow/config"
@@ -25,43 +29,173 @@ const (
 	ModifierHidden            = "hidden"
 	ModifierPresubmitOptional = "presubmit_optional"
 	ModifierPresubmitSkipped  = "presubmit_skipped"
+	ModifierSkipIfOnlyChanged = "skip_if_only_changed"
+	ModifierCancelPrevious    = "cancel_previous"
+	ModifierMaxConcurrency1   = "max_concurrency_1"
+	ModifierRunBeforeMerge    = "run_before_merge"
 )
 
-func ApplyModifiersPresubmit(presubmit *config.Presubmit, jobModifiers []string) {
-	for _, modifier := range jobModifiers {
-		switch modifier {
-		case ModifierPresubmitOptional:
+// Modifier changes the Prow jobs generated for a job. The functions are nil for
+// the types of jobs that the modifier has no effect on.
+type Modifier struct {
+	Presubmit  func(*config.Presubmit) error
+	Postsubmit func(*config.Postsubmit) error
+	Periodic   func(*config.Periodic) error
+}
+
+var modifiers = map[string]Modifier{
+	ModifierPresubmitOptional: {
+		Presubmit: func(presubmit *config.Presubmit) error {
 			presubmit.Optional = true
-		case ModifierHidden:
+			return nil
+		},
+	},
+	ModifierPresubmitSkipped: {
+		Presubmit: func(presubmit *config.Presubmit) error {
+			presubmit.AlwaysRun = false
+			return nil
+		},
+	},
+	ModifierHidden: {
+		Presubmit: func(presubmit *config.Presubmit) error {
 			presubmit.SkipReport = true
 			presubmit.ReporterConfig = &prowjob.ReporterConfig{
 				Slack: &prowjob.SlackReporterConfig{
 					JobStatesToReport: []prowjob.ProwJobState{},
 				},
 			}
-		case ModifierPresubmitSkipped:
-			presubmit.AlwaysRun = false
-		default:
-			log.Fatalf("Modifier %q is not unsupported", modifier)
-		}
+			return nil
+		},
+		Postsubmit: func(postsubmit *config.Postsubmit) error {
+			postsubmit.SkipReport = true
+			postsubmit.ReporterConfig = noSlackReport()
+			return nil
+		},
+		Periodic: func(periodic *config.Periodic) error {
+			periodic.ReporterConfig = noSlackReport()
+			return nil
+		},
+	},
+	// skip_if_only_changed uses the regex of the job to skip it if only the
+	// matching files are changed, instead of running it if any is changed.
+	ModifierSkipIfOnlyChanged: {
+		Presubmit: func(presubmit *config.Presubmit) error {
+			return skipIfOnlyChanged(&presubmit.RegexpChangeMatcher)
+		},
+		Postsubmit: func(postsubmit *config.Postsubmit) error {
+			return skipIfOnlyChanged(&postsubmit.RegexpChangeMatcher)
+		},
+	},
+	ModifierMaxConcurrency1: {
+		Presubmit: func(presubmit *config.Presubmit) error {
+			presubmit.MaxConcurrency = 1
+			return nil
+		},
+		Postsubmit: func(postsubmit *config.Postsubmit) error {
+			postsubmit.MaxConcurrency = 1
+			return nil
+		},
+		Periodic: func(periodic *config.Periodic) error {
+			periodic.MaxConcurrency = 1
+			return nil
+		},
+	},
+}
+
+// unsupportedModifiers are the known modifiers that cannot be applied, with
+// the reason.
+var unsupportedModifiers = map[string]string{
+	// Prow always aborts the running presubmit jobs when a new commit is
+	// pushed to the pull request, and cannot do it for the other types.
+	ModifierCancelPrevious: "Prow always aborts the running presubmits on a new commit, and cannot do it for the other types of jobs",
+	ModifierRunBeforeMerge: "the version of Prow the jobs are generated for does not support run_before_merge",
+}
+
+// RegisterModifier adds a modifier that can be used by the jobs, or replaces
+// the modifier with the same name. It must not be called while generating
+// the jobs.
+func RegisterModifier(name string, modifier Modifier) {
+	modifiers[name] = modifier
+	delete(unsupportedModifiers, name)
+}
+
+// LookupModifier returns the modifier registered with the name.
+func LookupModifier(name string) (Modifier, error) {
+	if m, ok := modifiers[name]; ok {
+		return m, nil
 	}
+	if reason, ok := unsupportedModifiers[name]; ok {
+		return Modifier{}, fmt.Errorf("modifier %q is not supported: %s", name, reason)
+	}
+	return Modifier{}, fmt.Errorf("modifier %q is not supported", name)
 }
 
-func ApplyModifiersPostsubmit(postsubmit *config.Postsubmit, jobModifiers []string) {
-	for _, modifier := range jobModifiers {
-		switch modifier {
-		case ModifierPresubmitOptional, ModifierPresubmitSkipped:
-			// No effect on postsubmit
-		case ModifierHidden:
-			postsubmit.SkipReport = true
-			f := false
-			postsubmit.ReporterConfig = &prowjob.ReporterConfig{
-				Slack: &prowjob.SlackReporterConfig{
-					Report: &f,
-				},
-			}
-		default:
-			log.Fatalf("Modifier %q is not unsupported", modifier)
+// Modifiers returns the names of all the registered modifiers, sorted.
+func Modifiers() []string {
+	res := make([]string, 0, len(modifiers))
+	for name := range modifiers {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func ApplyModifiersPresubmit(presubmit *config.Presubmit, jobModifiers []string) error {
+	return applyModifiers(jobModifiers, func(m Modifier) error {
+		if m.Presubmit == nil {
+			return nil
+		}
+		return m.Presubmit(presubmit)
+	})
+}
+
+func ApplyModifiersPostsubmit(postsubmit *config.Postsubmit, jobModifiers []string) error {
+	return applyModifiers(jobModifiers, func(m Modifier) error {
+		if m.Postsubmit == nil {
+			return nil
+		}
+		return m.Postsubmit(postsubmit)
+	})
+}
+
+func ApplyModifiersPeriodic(periodic *config.Periodic, jobModifiers []string) error {
+	return applyModifiers(jobModifiers, func(m Modifier) error {
+		if m.Periodic == nil {
+			return nil
+		}
+		return m.Periodic(periodic)
+	})
+}
+
+func applyModifiers(jobModifiers []string, apply func(Modifier) error) error {
+	var err error
+	for _, name := range jobModifiers {
+		m, e := LookupModifier(name)
+		if e != nil {
+			err = multierror.Append(err, e)
+			continue
+		}
+		if e := apply(m); e != nil {
+			err = multierror.Append(err, fmt.Errorf("modifier %q: %v", name, e))
 		}
 	}
+	return err
+}
+
+func noSlackReport() *prowjob.ReporterConfig {
+	f := false
+	return &prowjob.ReporterConfig{
+		Slack: &prowjob.SlackReporterConfig{
+			Report: &f,
+		},
+	}
+}
+
+func skipIfOnlyChanged(matcher *config.RegexpChangeMatcher) error {
+	if matcher.RunIfChanged == "" {
+		return errors.New("regex must be set")
+	}
+	matcher.SkipIfOnlyChanged = matcher.RunIfChanged
+	matcher.RunIfChanged = ""
+	return nil
 }
@@ -15,7 +15,7 @@
 package decorator
 
 import (
-	"log"
+	"fmt"
 
 	"github.com/hashicorp/go-multierror"
 	v1 "k8s.io/api/core/v1"
@@ -25,9 +25,12 @@ import (
 	"istio.io/test-infra/tools/prowgen/pkg/spec"
 )
 
+// MainContainerName is the name of the main test container of a job.
+const MainContainerName = "test"
+
 func ApplyRequirements(job *config.JobBase, requirements, excludedRequirements []string,
 	presetMap map[string]spec.RequirementPreset,
-) {
+) error {
 	validRequirements := sets.NewString()
 	for name := range presetMap {
 		validRequirements = validRequirements.Insert(name)
@@ -49,18 +52,42 @@ func ApplyRequirements(job *config.JobBase, requirements, excludedRequirements [
 			err = multierror.Append(err, e)
 		}
 	}
-	if err != nil {
-		log.Fatalf("Requirements validation failed: %v", err)
-	}
 
 	blocked := sets.NewString(excludedRequirements...)
 	presets := make([]spec.RequirementPreset, 0)
 	for _, req := range requirements {
 		if !blocked.Has(req) {
 			presets = append(presets, presetMap[req])
+			if e := validatePrivileged(req, presetMap[req], job.Spec); e != nil {
+				err = multierror.Append(err, e)
+			}
 		}
 	}
+	if err != nil {
+		return err
+	}
 	resolveRequirements(job.Annotations, job.Labels, job.Spec, presets)
+	return nil
+}
+
+// validatePrivileged checks that all the containers that use a requirement
+// that needs privilege are privileged.
+func validatePrivileged(reqName string, req spec.RequirementPreset, podSpec *v1.PodSpec) error {
+	if !req.Privileged || podSpec == nil {
+		return nil
+	}
+	var err error
+	for _, i := range targetContainers(podSpec.Containers, req.Containers) {
+		sc := podSpec.Containers[i].SecurityContext
+		if sc == nil || sc.Privileged == nil || !*sc.Privileged {
+			containerName := podSpec.Containers[i].Name
+			if containerName == "" {
+				containerName = MainContainerName
+			}
+			err = multierror.Append(err, fmt.Errorf("requirement %q needs privilege, but container %q is not privileged", reqName, containerName))
+		}
+	}
+	return err
 }
 
 func resolveRequirements(annotations, labels map[string]string, spec *v1.PodSpec, requirements []spec.RequirementPreset) {
@@ -80,11 +107,12 @@ func mergeRequirement(annotations, labels map[string]string, containers []v1.Con
 	for l, v := range req.Labels {
 		labels[l] = v
 	}
-	for i := range containers {
+	targets := targetContainers(containers, req.Containers)
+	for _, i := range targets {
 		containers[i].Args = append(containers[i].Args, req.Args...)
 	}
 	for _, e1 := range req.Env {
-		for i := range containers {
+		for _, i := range targets {
 			exists := false
 			for _, e2 := range containers[i].Env {
 				if e2.Name == e1.Name {
@@ -110,7 +138,7 @@ func mergeRequirement(annotations, labels map[string]string, containers []v1.Con
 		}
 	}
 	for _, vm1 := range req.VolumeMounts {
-		for i := range containers {
+		for _, i := range targets {
 			exists := false
 			for _, vm2 := range containers[i].VolumeMounts {
 				if vm2.MountPath == vm1.MountPath {
@@ -124,3 +152,27 @@ func mergeRequirement(annotations, labels map[string]string, containers []v1.Con
 		}
 	}
 }
+
+// targetContainers returns the indexes of the containers with the given names.
+// The first container is the main test container, and it's the only target if
+// no names are given.
+func targetContainers(containers []v1.Container, names []string) []int {
+	if len(containers) == 0 {
+		return nil
+	}
+	if len(names) == 0 {
+		return []int{0}
+	}
+	nameSet := sets.NewString(names...)
+	targets := make([]int, 0)
+	for i, c := range containers {
+		name := c.Name
+		if i == 0 && name == "" {
+			name = MainContainerName
+		}
+		if nameSet.Has(name) {
+			targets = append(targets, i)
+		}
+	}
+	return targets
+}
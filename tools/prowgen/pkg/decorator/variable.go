@@ -15,12 +15,14 @@
 package decorator
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"regexp"
+	"sort"
 	"strings"
 
-	"sigs.k8s.io/yaml"
+	"github.com/hashicorp/go-multierror"
+	"k8s.io/apimachinery/pkg/util/sets"
 
 	"istio.io/test-infra/tools/prowgen/pkg/spec"
 )
@@ -30,8 +32,6 @@ const (
 	paramsPrefix = "params."
 )
 
-var variableSubstitutionRegex = regexp.MustCompile(`\$\([_a-zA-Z0-9.-]+(\.[_a-zA-Z0-9.-]+)*\)`)
-
 func applyArch(arch string, job spec.Job, clusterOverrides map[string]string) spec.Job {
 	// For backwards compatibility, amd64 is not suffixed
 	if arch != "amd64" {
@@ -45,125 +45,241 @@ func applyArch(arch string, job spec.Job, clusterOverrides map[string]string) sp
 	if c, f := clusterOverrides[arch]; f {
 		job.Cluster = c
 	}
+
+	if ac, f := job.ArchitectureConfigs[arch]; f {
+		if ac.Image != "" {
+			job.Image = ac.Image
+		}
+		if ac.Resources != "" {
+			job.Resources = ac.Resources
+		}
+		if ac.Cluster != "" {
+			job.Cluster = ac.Cluster
+		}
+		// Like in the other layers, they are replaced as a whole.
+		if len(ac.Tolerations) != 0 {
+			job.Tolerations = ac.Tolerations
+		}
+		if ac.Affinity != nil {
+			job.Affinity = ac.Affinity
+		}
+		job.Requirements = append(append([]string{}, job.Requirements...), ac.Requirements...)
+		job.ExcludedRequirements = append(append([]string{}, job.ExcludedRequirements...), ac.ExcludedRequirements...)
+	}
 	return job
 }
 
+// ApplyVariables resolves the expressions in the job for each architecture and
+// each combination of the matrix values. The builtins are the variables that
+// can be referenced without a prefix, i.e. branch, org and repo.
 func ApplyVariables(
 	job spec.Job,
 	architectures []string,
 	params map[string]string,
-	matrix map[string][]string,
+	matrix *spec.Matrix,
+	builtins map[string]string,
 	overrides map[string]string,
-) []spec.Job {
-	yamlBS, err := yaml.Marshal(job)
+) ([]spec.Job, error) {
+	bs, err := json.Marshal(job)
 	if err != nil {
-		log.Fatalf("Failed to marshal the given Job: %v", err)
+		return nil, fmt.Errorf("failed to marshal the given Job: %v", err)
+	}
+	var tree interface{}
+	if err := json.Unmarshal(bs, &tree); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the given Job: %v", err)
+	}
+	if matrix == nil {
+		matrix = &spec.Matrix{}
 	}
 
-	jobs := make([]spec.Job, 0)
+	exps := make([]expression, 0)
+	walkStrings(tree, func(s string) string {
+		exps = append(exps, findExpressions(s)...)
+		return s
+	})
 
+	// The dimensions are ordered as they appear in the YAML form of the job,
+	// which determines the order of the generated jobs. The dimensions that
+	// are not configured are reported when evaluating the expressions.
+	dimensions := make([]string, 0)
+	seen := map[string]bool{}
+	for _, exp := range exps {
+		for _, dimension := range exp.matrixDimensions() {
+			if _, ok := matrix.Dimensions[dimension]; ok && !seen[dimension] {
+				seen[dimension] = true
+				dimensions = append(dimensions, dimension)
+			}
+		}
+	}
+	// The dimensions that are not referenced in the job name are appended to
+	// it, so that each combination has a different name.
+	nameDimensions := map[string]bool{}
+	for _, exp := range findExpressions(job.Name) {
+		for _, dimension := range exp.matrixDimensions() {
+			nameDimensions[dimension] = true
+		}
+	}
+
+	jobs := make([]spec.Job, 0)
 	for _, arch := range architectures {
-		subsExps := getVarSubstitutionExpressions(string(yamlBS))
-		if len(subsExps) == 0 && len(architectures) == 1 {
+		if len(exps) == 0 && len(architectures) == 1 {
 			jobs = append(jobs, applyArch(arch, job, overrides))
 			continue
 		}
-		if params == nil {
-			params = map[string]string{}
+		ctx := evalContext{params: map[string]string{"arch": arch}, builtins: builtins}
+		for k, v := range params {
+			ctx.params[k] = v
 		}
-		params["arch"] = arch
 
-		resolvedYAMLStr := applyParams(string(yamlBS), subsExps, params)
-		resolvedYAMLStrs := applyMatrix(resolvedYAMLStr, subsExps, matrix)
+		names := map[string]map[string]string{}
+		for _, values := range matrixCombinations(dimensions, matrix) {
+			ctx.matrix = values
+			var errs []string
+			resolved := walkStrings(tree, func(s string) string {
+				for _, exp := range findExpressions(s) {
+					val, err := exp.eval(ctx)
+					if err != nil {
+						errs = append(errs, err.Error())
+						continue
+					}
+					s = strings.ReplaceAll(s, exp.raw, val)
+				}
+				return s
+			})
+			if len(errs) != 0 {
+				var err error
+				for _, e := range sets.NewString(errs...).List() {
+					err = multierror.Append(err, errors.New(e))
+				}
+				return nil, err
+			}
 
-		for _, jobYaml := range resolvedYAMLStrs {
+			bs, err := json.Marshal(resolved)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal the resolved Job: %v", err)
+			}
 			job := spec.Job{}
-			if err := yaml.Unmarshal([]byte(jobYaml), &job); err != nil {
-				log.Fatalf("Failed to unmarshal the yaml to Job: %v", err)
+			if err := json.Unmarshal(bs, &job); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal the resolved Job: %v", err)
+			}
+			for _, dimension := range dimensions {
+				if !nameDimensions[dimension] {
+					job.Name += "-" + values[dimension]
+				}
+			}
+			if other, ok := names[job.Name]; ok {
+				return nil, fmt.Errorf("matrix combinations %v and %v generate the same job name %q", other, values, job.Name)
 			}
+			names[job.Name] = values
 			jobs = append(jobs, applyArch(arch, job, overrides))
 		}
 	}
-	return jobs
+	return jobs, nil
 }
 
-// applyParams will resolve all the $(params.key) expressions into the
-// configured values.
-func applyParams(yamlStr string, subsExps []string, params map[string]string) string {
-	for _, exp := range subsExps {
-		if strings.HasPrefix(exp, paramsPrefix) {
-			exp = strings.TrimPrefix(exp, paramsPrefix)
-			if val, ok := params[exp]; ok {
-				yamlStr = replace(yamlStr, paramsPrefix, exp, val)
-			} else {
-				log.Fatalf("Param %q not configured in the params map %v", exp, params)
-			}
+// walkStrings calls fn for all the strings in the JSON value, including the map
+// keys, in the order they appear in its YAML form, and returns the value with
+// the strings replaced by the results of fn.
+func walkStrings(v interface{}, fn func(string) string) interface{} {
+	switch t := v.(type) {
+	case string:
+		return fn(t)
+	case []interface{}:
+		res := make([]interface{}, len(t))
+		for i := range t {
+			res[i] = walkStrings(t[i], fn)
+		}
+		return res
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		res := make(map[string]interface{}, len(t))
+		for _, k := range keys {
+			res[fn(k)] = walkStrings(t[k], fn)
 		}
+		return res
+	default:
+		return v
 	}
-	return yamlStr
 }
 
-// applyMatrix will resolve all the $(matrix.dimension) expressions into the
-// configured lists of values, and then calculate all the combinations.
-func applyMatrix(yamlStr string, subsExps []string, matrix map[string][]string) []string {
-	combs := make([]string, 0)
-	for _, exp := range subsExps {
-		if strings.HasPrefix(exp, matrixPrefix) {
-			exp = strings.TrimPrefix(exp, matrixPrefix)
-			if _, ok := matrix[exp]; ok {
-				combs = append(combs, exp)
-			} else {
-				log.Fatalf("Dimension %q not configured in the matrix %v", exp, matrix)
+// matrixCombinations calculates all the combinations of the values of the
+// dimensions, with the exclude and include entries of the matrix applied.
+func matrixCombinations(dimensions []string, matrix *spec.Matrix) []map[string]string {
+	values := &[]map[string]string{}
+	resolveCombinations(dimensions, map[string]string{}, 0, matrix.Dimensions, values)
+
+	res := make([]map[string]string, 0)
+	seen := map[string]bool{}
+	add := func(vals map[string]string) {
+		// Only the referenced dimensions make a combination different.
+		key := make([]string, 0, len(dimensions))
+		for _, dimension := range dimensions {
+			key = append(key, vals[dimension])
+		}
+		if seen[strings.Join(key, "\x00")] {
+			return
+		}
+		seen[strings.Join(key, "\x00")] = true
+		res = append(res, vals)
+	}
+	for _, vals := range *values {
+		if !matchesAny(vals, dimensions, matrix.Exclude) {
+			add(vals)
+		}
+	}
+	for _, include := range matrix.Include {
+		vals := map[string]string{}
+		for _, dimension := range dimensions {
+			if v, ok := include[dimension]; ok {
+				vals[dimension] = v
 			}
 		}
+		if len(vals) == len(dimensions) && len(dimensions) != 0 {
+			add(vals)
+		}
 	}
+	return res
+}
 
-	res := &[]string{}
-	resolveCombinations(combs, yamlStr, 0, matrix, res)
-	return *res
+// matchesAny returns whether the combination of the values matches any of the
+// entries, which only apply if all their dimensions are referenced.
+func matchesAny(values map[string]string, dimensions []string, entries []map[string]string) bool {
+	referenced := map[string]bool{}
+	for _, dimension := range dimensions {
+		referenced[dimension] = true
+	}
+	for _, entry := range entries {
+		matches := len(entry) != 0
+		for dimension, value := range entry {
+			if !referenced[dimension] || values[dimension] != value {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
 }
 
-func resolveCombinations(combs []string, dest string, start int, matrix map[string][]string, res *[]string) {
+func resolveCombinations(combs []string, dest map[string]string, start int, matrix map[string][]string, res *[]map[string]string) {
 	if start == len(combs) {
-		*res = append(*res, dest)
+		vals := make(map[string]string, len(dest))
+		for k, v := range dest {
+			vals[k] = v
+		}
+		*res = append(*res, vals)
 		return
 	}
 
 	lst := matrix[combs[start]]
 	for i := range lst {
-		dest := replace(dest, matrixPrefix, combs[start], lst[i])
+		dest[combs[start]] = lst[i]
 		resolveCombinations(combs, dest, start+1, matrix, res)
 	}
 }
-
-// replace replaces the expressions written as $(prefix.expKey) with the expVal
-func replace(str, expType, expKey, expVal string) string {
-	return strings.ReplaceAll(str, fmt.Sprintf("$(%s%s)", expType, expKey), expVal)
-}
-
-// getVarSubstitutionExpressions extracts all the value between "$(" and ")""
-func getVarSubstitutionExpressions(yamlStr string) []string {
-	allExpressions := validateString(yamlStr)
-	return allExpressions
-}
-
-func validateString(value string) []string {
-	expressions := variableSubstitutionRegex.FindAllString(value, -1)
-	if expressions == nil {
-		return nil
-	}
-	var result []string
-	set := map[string]bool{}
-	for _, expression := range expressions {
-		expression = stripVarSubExpression(expression)
-		if _, ok := set[expression]; !ok {
-			result = append(result, expression)
-			set[expression] = true
-		}
-	}
-	return result
-}
-
-func stripVarSubExpression(expression string) string {
-	return strings.TrimSuffix(strings.TrimPrefix(expression, "$("), ")")
-}
@@ -0,0 +1,275 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"k8s.io/test-infra/prow/config"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "modified"
+)
+
+// JobDiff is the semantic difference of a single Prow job between the current
+// and the newly generated config.
+type JobDiff struct {
+	Type    string
+	OrgRepo string
+	Name    string
+	Change  string
+	// Fields is only set for modified jobs.
+	Fields []FieldDiff
+}
+
+// FieldDiff is a single field that differs between the current and the newly
+// generated version of a job. An empty Old or New means the field is unset.
+type FieldDiff struct {
+	Path string
+	Old  string
+	New  string
+}
+
+// Diff parses the current config file and compares it with the generated Prow
+// jobs job by job. A current config file that does not exist yet is treated as
+// an empty config, so all the generated jobs will be reported as added.
+func Diff(jobs config.JobConfig, currentConfigFile string) ([]JobDiff, error) {
+	current := config.JobConfig{}
+	bs, err := ioutil.ReadFile(currentConfigFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read current config for %s: %v", currentConfigFile, err)
+	}
+	if err == nil {
+		if err := yaml.Unmarshal(bs, &current); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal current config %s: %v", currentConfigFile, err)
+		}
+	}
+	return DiffJobConfigs(current, jobs)
+}
+
+// DiffJobConfigs compares two Prow job configs and returns the added, removed
+// and modified jobs, sorted by job type, org/repo and name.
+func DiffJobConfigs(current, generated config.JobConfig) ([]JobDiff, error) {
+	currentJobs, err := flattenJobConfig(current)
+	if err != nil {
+		return nil, err
+	}
+	generatedJobs, err := flattenJobConfig(generated)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := make([]JobDiff, 0)
+	for key, newFields := range generatedJobs {
+		oldFields, ok := currentJobs[key]
+		if !ok {
+			diffs = append(diffs, JobDiff{Type: key.typ, OrgRepo: key.orgRepo, Name: key.name, Change: ChangeAdded})
+			continue
+		}
+		if fields := diffFields(oldFields, newFields); len(fields) != 0 {
+			diffs = append(diffs, JobDiff{Type: key.typ, OrgRepo: key.orgRepo, Name: key.name, Change: ChangeModified, Fields: fields})
+		}
+	}
+	for key := range currentJobs {
+		if _, ok := generatedJobs[key]; !ok {
+			diffs = append(diffs, JobDiff{Type: key.typ, OrgRepo: key.orgRepo, Name: key.name, Change: ChangeRemoved})
+		}
+	}
+
+	typeOrder := map[string]int{TypePresubmit: 0, TypePostsubmit: 1, TypePeriodic: 2}
+	sort.Slice(diffs, func(i, j int) bool {
+		if diffs[i].Type != diffs[j].Type {
+			return typeOrder[diffs[i].Type] < typeOrder[diffs[j].Type]
+		}
+		if diffs[i].OrgRepo != diffs[j].OrgRepo {
+			return diffs[i].OrgRepo < diffs[j].OrgRepo
+		}
+		return diffs[i].Name < diffs[j].Name
+	})
+	return diffs, nil
+}
+
+// FormatDiffs renders the job diffs in a human readable form.
+func FormatDiffs(diffs []JobDiff) string {
+	var sb strings.Builder
+	for _, d := range diffs {
+		id := d.Name
+		if d.OrgRepo != "" {
+			id = d.OrgRepo + " " + d.Name
+		}
+		switch d.Change {
+		case ChangeAdded:
+			fmt.Fprintf(&sb, "+ %s %s\n", d.Type, id)
+		case ChangeRemoved:
+			fmt.Fprintf(&sb, "- %s %s\n", d.Type, id)
+		case ChangeModified:
+			fmt.Fprintf(&sb, "~ %s %s\n", d.Type, id)
+			for _, f := range d.Fields {
+				fmt.Fprintf(&sb, "    %s: %s -> %s\n", f.Path, orUnset(f.Old), orUnset(f.New))
+			}
+		}
+	}
+	return sb.String()
+}
+
+func orUnset(s string) string {
+	if s == "" {
+		return "<unset>"
+	}
+	return s
+}
+
+type jobKey struct {
+	typ     string
+	orgRepo string
+	name    string
+}
+
+// flattenJobConfig converts each job in the config into a map of field path to
+// its JSON encoded value, so that jobs can be compared field by field.
+func flattenJobConfig(jc config.JobConfig) (map[jobKey]map[string]string, error) {
+	res := map[jobKey]map[string]string{}
+	add := func(key jobKey, job interface{}) error {
+		fields, err := flattenObject(job)
+		if err != nil {
+			return fmt.Errorf("failed to flatten %s %s: %v", key.typ, key.name, err)
+		}
+		res[key] = fields
+		return nil
+	}
+	for orgRepo, jobs := range jc.PresubmitsStatic {
+		for _, job := range jobs {
+			if err := add(jobKey{TypePresubmit, orgRepo, job.Name}, job); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for orgRepo, jobs := range jc.PostsubmitsStatic {
+		for _, job := range jobs {
+			if err := add(jobKey{TypePostsubmit, orgRepo, job.Name}, job); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, job := range jc.Periodics {
+		if err := add(jobKey{TypePeriodic, "", job.Name}, job); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// flattenObject marshals the object to JSON and flattens it into a map of
+// field path to value.
+func flattenObject(obj interface{}) (map[string]string, error) {
+	bs, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(bs, &v); err != nil {
+		return nil, err
+	}
+	res := map[string]string{}
+	flattenValue("", v, res)
+	return res, nil
+}
+
+func flattenValue(path string, v interface{}, res map[string]string) {
+	switch tv := v.(type) {
+	case map[string]interface{}:
+		for k, val := range tv {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			flattenValue(p, val, res)
+		}
+	case []interface{}:
+		// Lists of scalars such as command, args or branches are easier to
+		// read as a whole.
+		if isScalarList(tv) {
+			bs, _ := json.Marshal(tv)
+			res[path] = string(bs)
+			return
+		}
+		// Lists of named objects such as env, volumes and volumeMounts are
+		// keyed by the name so that reordering is not reported as a change.
+		named := namedElements(tv)
+		for i, e := range tv {
+			if named {
+				flattenValue(fmt.Sprintf("%s[%s]", path, e.(map[string]interface{})["name"]), e, res)
+			} else {
+				flattenValue(fmt.Sprintf("%s[%d]", path, i), e, res)
+			}
+		}
+	default:
+		bs, _ := json.Marshal(tv)
+		res[path] = string(bs)
+	}
+}
+
+func isScalarList(lst []interface{}) bool {
+	for _, e := range lst {
+		switch e.(type) {
+		case map[string]interface{}, []interface{}:
+			return false
+		}
+	}
+	return true
+}
+
+func namedElements(lst []interface{}) bool {
+	seen := map[string]bool{}
+	for _, e := range lst {
+		m, ok := e.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		name, ok := m["name"].(string)
+		if !ok || name == "" || seen[name] {
+			return false
+		}
+		seen[name] = true
+	}
+	return true
+}
+
+func diffFields(oldFields, newFields map[string]string) []FieldDiff {
+	res := make([]FieldDiff, 0)
+	for p, nv := range newFields {
+		if ov := oldFields[p]; ov != nv {
+			res = append(res, FieldDiff{Path: p, Old: ov, New: nv})
+		}
+	}
+	for p, ov := range oldFields {
+		if _, ok := newFields[p]; !ok {
+			res = append(res, FieldDiff{Path: p, Old: ov})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Path < res[j].Path
+	})
+	return res
+}
@@ -0,0 +1,83 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/test-infra/prow/config"
+)
+
+func presubmitWith(name, image string, args []string, env []v1.EnvVar, labels map[string]string) config.Presubmit {
+	return config.Presubmit{
+		JobBase: config.JobBase{
+			Name:   name,
+			Labels: labels,
+			Spec: &v1.PodSpec{
+				Containers: []v1.Container{{Image: image, Args: args, Env: env}},
+			},
+		},
+		Brancher: config.Brancher{Branches: []string{"^master$"}},
+	}
+}
+
+func TestDiffJobConfigs(t *testing.T) {
+	current := config.JobConfig{
+		PresubmitsStatic: map[string][]config.Presubmit{
+			"istio/istio": {
+				presubmitWith("unchanged", "image:1", []string{"a"}, nil, nil),
+				presubmitWith("modified", "image:1", []string{"a", "b"},
+					[]v1.EnvVar{{Name: "FOO", Value: "1"}, {Name: "BAR", Value: "1"}}, map[string]string{"l": "v"}),
+				presubmitWith("removed", "image:1", nil, nil, nil),
+			},
+		},
+	}
+	generated := config.JobConfig{
+		PresubmitsStatic: map[string][]config.Presubmit{
+			"istio/istio": {
+				presubmitWith("unchanged", "image:1", []string{"a"}, nil, nil),
+				presubmitWith("modified", "image:2", []string{"a", "c"},
+					[]v1.EnvVar{{Name: "BAR", Value: "1"}, {Name: "FOO", Value: "2"}}, nil),
+			},
+		},
+		Periodics: []config.Periodic{{JobBase: config.JobBase{Name: "added"}, Cron: "0 1 * * *"}},
+	}
+
+	want := []JobDiff{
+		{
+			Type:    TypePresubmit,
+			OrgRepo: "istio/istio",
+			Name:    "modified",
+			Change:  ChangeModified,
+			Fields: []FieldDiff{
+				{Path: "labels.l", Old: `"v"`},
+				{Path: "spec.containers[0].args", Old: `["a","b"]`, New: `["a","c"]`},
+				{Path: "spec.containers[0].env[FOO].value", Old: `"1"`, New: `"2"`},
+				{Path: "spec.containers[0].image", Old: `"image:1"`, New: `"image:2"`},
+			},
+		},
+		{Type: TypePresubmit, OrgRepo: "istio/istio", Name: "removed", Change: ChangeRemoved},
+		{Type: TypePeriodic, Name: "added", Change: ChangeAdded},
+	}
+	got, err := DiffJobConfigs(current, generated)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("Job diffs do not match, (-want, +got): \n%s", diff)
+	}
+}
@@ -0,0 +1,691 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"istio.io/test-infra/tools/prowgen/pkg/spec"
+)
+
+const (
+	LayerBase     = "base"
+	LayerImport   = "import"
+	LayerFile     = "file"
+	LayerExtends  = "extends"
+	LayerJob      = "job"
+	LayerOverride = "override"
+	// LayerArchitecture is the architecture config of one of the architectures
+	// of the job, which only explains the requirements.
+	LayerArchitecture = "architecture"
+)
+
+// ExplainLayer is one of the configs a job is merged from.
+type ExplainLayer struct {
+	// Kind is one of base, import, file, extends, job, override and
+	// architecture.
+	Kind string
+	File string
+	// Job is the name of the job of the extends and job layers, and of the
+	// override layers patching the job.
+	Job string
+	// Branch is the branch of the override layers.
+	Branch string
+	// Architecture is the architecture of the architecture layers.
+	Architecture string
+
+	common spec.CommonConfig
+	// job is the job of the extends and job layers and of the override
+	// layers patching the job, whose fields other than the common config are
+	// only inherited if they are not set.
+	job *spec.Job
+}
+
+// FieldOrigin is a field of the final job, with the layer that set it.
+type FieldOrigin struct {
+	// Path is the path of the field, e.g. image, labels.foo or env[0].
+	Path string
+	// Value is the value of the field in JSON.
+	Value string
+	// Layer is the index of the layer that set the field.
+	Layer int
+}
+
+// RequirementOrigin tells whether a requirement preset is applied to the job,
+// and why.
+type RequirementOrigin struct {
+	Name string
+	// Architecture is the architecture the requirement is explained for when
+	// the architecture configs of the job change it, or empty if it's the
+	// same for all the architectures of the job.
+	Architecture string
+	// RequiredBy and ExcludedBy are the indexes of the layers listing the
+	// requirement in requirements and excluded_requirements respectively.
+	RequiredBy []int
+	ExcludedBy []int
+	// Preset is the index of the layer that defines the preset, or -1 if no
+	// layer does.
+	Preset int
+}
+
+// Applied returns whether the requirement preset is applied to the job.
+func (r RequirementOrigin) Applied() bool {
+	return len(r.RequiredBy) != 0 && len(r.ExcludedBy) == 0
+}
+
+// ExplainedProwJob is a Prow job generated for the job.
+type ExplainedProwJob struct {
+	Branch string
+	Type   string
+	Name   string
+}
+
+// Explanation tells where each field of a job defined in a meta config file
+// comes from.
+type Explanation struct {
+	File string
+	Org  string
+	Repo string
+	Job  string
+	// Branches are the branches the job is explained for, i.e. that have the
+	// same layers.
+	Branches []string
+
+	// Layers are the configs the job is merged from, in the order they are
+	// applied.
+	Layers       []ExplainLayer
+	Fields       []FieldOrigin
+	Requirements []RequirementOrigin
+	ProwJobs     []ExplainedProwJob
+}
+
+// Explain explains the jobs of the meta config file that are named name, or
+// that generate a Prow job named name. baseFiles are the .base.yaml files the
+// base config of the client is read from, in order. The branches whose
+// branch_overrides change the job are explained separately, with the override
+// of the branch and the patch of the job as the last layers, and only the
+// branches of the Prow jobs named name are explained.
+func (cli *Client) Explain(baseFiles []string, file, name string) ([]Explanation, error) {
+	jobsConfig, err := cli.ReadJobsConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := ReadRawJobsConfig(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var baseLayers []ExplainLayer
+	res := make([]Explanation, 0)
+	for i, job := range jobsConfig.Jobs {
+		prowJobs, err := cli.explainProwJobs(file, jobsConfig, job)
+		if err != nil && job.Name != name {
+			// The job cannot generate a Prow job named name.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		branches := sets.NewString()
+		for _, pj := range prowJobs {
+			if job.Name == name || pj.Name == name {
+				branches.Insert(pj.Branch)
+			}
+		}
+		if branches.Len() == 0 && job.Name != name {
+			continue
+		}
+
+		if baseLayers == nil {
+			if baseLayers, err = explainBaseLayers(baseFiles); err != nil {
+				return nil, err
+			}
+		}
+		layers := append(append([]ExplainLayer{}, baseLayers...), ExplainLayer{Kind: LayerFile, File: file, common: raw.CommonConfig})
+		layers = append(layers, explainJobLayers(file, raw.Jobs, i)...)
+		for _, v := range explainOverrides(file, jobsConfig, raw.BranchOverrides, job) {
+			if !branches.HasAny(v.branches...) && job.Name != name {
+				continue
+			}
+			variantLayers := append(append([]ExplainLayer{}, layers...), v.layers...)
+			variantLayers = append(variantLayers, explainArchLayers(v.job)...)
+			variantProwJobs := make([]ExplainedProwJob, 0)
+			for _, pj := range prowJobs {
+				if sets.NewString(v.branches...).Has(pj.Branch) {
+					variantProwJobs = append(variantProwJobs, pj)
+				}
+			}
+			res = append(res, Explanation{
+				File:         file,
+				Org:          jobsConfig.Org,
+				Repo:         jobsConfig.Repo,
+				Job:          job.Name,
+				Branches:     v.branches,
+				Layers:       variantLayers,
+				Fields:       explainFields(variantLayers, v.job),
+				Requirements: explainRequirements(variantLayers, v.job),
+				ProwJobs:     variantProwJobs,
+			})
+		}
+	}
+	return res, nil
+}
+
+// explainedVariant is the job as it's generated for some of the branches.
+type explainedVariant struct {
+	branches []string
+	// layers are the override layers of the branches, if any.
+	layers []ExplainLayer
+	job    spec.Job
+}
+
+// explainOverrides returns the variants of the job for the branches of the
+// file. The branches whose branch_overrides do not change the job share the
+// variant without override layers, and each of the other branches has its own,
+// unless the job is excluded for it. raw are the overrides as they are written.
+func explainOverrides(file string, jobsConfig spec.JobsConfig, raw map[string]spec.BranchOverride, job spec.Job) []explainedVariant {
+	res := make([]explainedVariant, 0)
+	// The index of the variant shared by the branches without override.
+	shared := -1
+	for _, branch := range jobsConfig.Branches {
+		override, ok := raw[branch]
+		var patches []spec.Job
+		for _, patch := range override.Jobs {
+			if patch.Name == job.Name {
+				patches = append(patches, patch)
+			}
+		}
+		if sets.NewString(override.ExcludedJobs...).Has(job.Name) {
+			continue
+		}
+		if !ok || (reflect.DeepEqual(override.CommonConfig, spec.CommonConfig{}) && len(patches) == 0) {
+			if shared == -1 {
+				shared = len(res)
+				res = append(res, explainedVariant{job: job})
+			}
+			res[shared].branches = append(res[shared].branches, branch)
+			continue
+		}
+
+		layers := []ExplainLayer{{Kind: LayerOverride, File: file, Branch: branch, common: override.CommonConfig}}
+		for k := range patches {
+			layers = append(layers, ExplainLayer{
+				Kind: LayerOverride, File: file, Branch: branch, Job: job.Name, common: patches[k].CommonConfig, job: &patches[k],
+			})
+		}
+		jc := jobsConfig
+		jc.Jobs = []spec.Job{job}
+		res = append(res, explainedVariant{
+			branches: []string{branch},
+			layers:   layers,
+			job:      applyBranchOverride(jc, branch).Jobs[0],
+		})
+	}
+	return res
+}
+
+// BaseFiles returns the .base.yaml files the base config of the meta config
+// files in dir is read from, in order, i.e. the ones of the input directory and
+// of dir. The one of the input directory is only read once for its own meta
+// config files.
+func BaseFiles(inputDir, dir string) []string {
+	dirs := []string{inputDir}
+	if filepath.Clean(dir) != filepath.Clean(inputDir) {
+		dirs = append(dirs, dir)
+	}
+	res := make([]string, 0)
+	for _, d := range dirs {
+		file := filepath.Join(d, ".base.yaml")
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			res = append(res, file)
+		}
+	}
+	return res
+}
+
+// explainProwJobs returns the Prow jobs generated for the job for all the
+// branches.
+func (cli *Client) explainProwJobs(file string, jobsConfig spec.JobsConfig, job spec.Job) ([]ExplainedProwJob, error) {
+	// The needs only reference the other jobs, and do not change the Prow
+	// jobs generated for the job.
+	job.Needs = nil
+	jobsConfig.Jobs = []spec.Job{job}
+	// The branch overrides can only reference the job now.
+	overrides := map[string]spec.BranchOverride{}
+	for branch, override := range jobsConfig.BranchOverrides {
+		patches := make([]spec.Job, 0)
+		for _, patch := range override.Jobs {
+			if patch.Name == job.Name {
+				patches = append(patches, patch)
+			}
+		}
+		override.Jobs = patches
+		if !sets.NewString(override.ExcludedJobs...).Has(job.Name) {
+			override.ExcludedJobs = nil
+		} else {
+			override.ExcludedJobs = []string{job.Name}
+		}
+		overrides[branch] = override
+	}
+	jobsConfig.BranchOverrides = overrides
+
+	res := make([]ExplainedProwJob, 0)
+	for _, branch := range jobsConfig.Branches {
+		output, err := cli.ConvertJobConfig(file, jobsConfig, branch)
+		if err != nil {
+			return nil, err
+		}
+		for _, jobs := range output.PresubmitsStatic {
+			for _, j := range jobs {
+				res = append(res, ExplainedProwJob{Branch: branch, Type: TypePresubmit, Name: j.Name})
+			}
+		}
+		for _, jobs := range output.PostsubmitsStatic {
+			for _, j := range jobs {
+				res = append(res, ExplainedProwJob{Branch: branch, Type: TypePostsubmit, Name: j.Name})
+			}
+		}
+		for _, j := range output.Periodics {
+			res = append(res, ExplainedProwJob{Branch: branch, Type: TypePeriodic, Name: j.Name})
+		}
+	}
+	return res, nil
+}
+
+// explainBaseLayers returns the layers of the base config files, each preceded
+// by the preset libraries it imports, the same way as ReadBase merges them.
+func explainBaseLayers(baseFiles []string) ([]ExplainLayer, error) {
+	res := make([]ExplainLayer, 0)
+	for _, file := range baseFiles {
+		bc, err := ReadRawBase(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, imp := range importPaths(file, bc.Imports) {
+			lib, err := readPresetLibrary(imp)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, ExplainLayer{Kind: LayerImport, File: imp, common: spec.CommonConfig{
+				ResourcePresets:    lib.ResourcePresets,
+				RequirementPresets: lib.RequirementPresets,
+			}})
+		}
+		res = append(res, ExplainLayer{Kind: LayerBase, File: file, common: bc.CommonConfig})
+	}
+	return res, nil
+}
+
+// explainJobLayers returns the layers of the job at index i and of the jobs it
+// extends, parents first. The extends are already validated by
+// ReadJobsConfig.
+func explainJobLayers(file string, jobs []spec.Job, i int) []ExplainLayer {
+	chain := []spec.Job{jobs[i]}
+	seen := sets.NewString(jobs[i].Name)
+	for parent := jobs[i].Extends; parent != "" && !seen.Has(parent); {
+		seen.Insert(parent)
+		found := false
+		for _, j := range jobs {
+			if j.Name == parent {
+				chain = append([]spec.Job{j}, chain...)
+				parent, found = j.Extends, true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+
+	res := make([]ExplainLayer, 0, len(chain))
+	for k := range chain {
+		kind := LayerExtends
+		if k == len(chain)-1 {
+			kind = LayerJob
+		}
+		res = append(res, ExplainLayer{Kind: kind, File: file, Job: chain[k].Name, common: chain[k].CommonConfig, job: &chain[k]})
+	}
+	return res
+}
+
+// explainedField is a field of the job in the meta config files.
+type explainedField struct {
+	name string
+	kind reflect.Kind
+	// common is whether the field is in the common config, i.e. merged
+	// across the layers, instead of only inherited if it's not set.
+	common bool
+}
+
+// explainedFields returns the fields of the type in order, with the fields of
+// the embedded structs in place.
+func explainedFields(t reflect.Type, common bool) []explainedField {
+	res := make([]explainedField, 0)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			res = append(res, explainedFields(f.Type, f.Type == reflect.TypeOf(spec.CommonConfig{}))...)
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		res = append(res, explainedField{name: name, kind: f.Type.Kind(), common: common})
+	}
+	return res
+}
+
+// jsonValues returns the JSON values of the fields of v that are set.
+func jsonValues(v interface{}) map[string]json.RawMessage {
+	bs, _ := json.Marshal(v)
+	values := map[string]json.RawMessage{}
+	_ = json.Unmarshal(bs, &values)
+	for k, v := range values {
+		switch string(v) {
+		case "null", `""`, "0", "false", "{}", "[]":
+			delete(values, k)
+		}
+	}
+	return values
+}
+
+// explainFields attributes each field of the final job to the layer that set
+// it. The layers are merged one by one with mergeCommonConfig and mergeJob, the
+// same way as the job is generated, and each field, map entry and list item is
+// attributed to the last layer that set or changed it.
+// The presets are not included, except the resource preset used by the job,
+// and neither are the requirements, which are explained separately.
+// The architecture layers are skipped since they only apply to some of the
+// Prow jobs.
+func explainFields(layers []ExplainLayer, job spec.Job) []FieldOrigin {
+	fields := explainedFields(reflect.TypeOf(spec.Job{}), false)
+	// The job fields such as command are more relevant than the common config.
+	sort.SliceStable(fields, func(i, j int) bool {
+		return !fields[i].common && fields[j].common
+	})
+	resources := job.Resources
+	if resources == "" {
+		resources = "default"
+	}
+
+	origins := map[string][]FieldOrigin{}
+	merged := spec.Job{}
+	for i, layer := range layers {
+		if layer.Kind == LayerArchitecture {
+			continue
+		}
+		next := merged.DeepCopy()
+		var set map[string]json.RawMessage
+		if layer.job != nil {
+			next = mergeJob(merged, *layer.job)
+			set = jsonValues(layer.job)
+		} else {
+			next.CommonConfig = mergeCommonConfig(merged.CommonConfig, layer.common)
+			set = jsonValues(layer.common)
+		}
+		prev, cur := jsonValues(merged), jsonValues(next)
+		for _, f := range fields {
+			origins[f.name] = explainField(f, prev[f.name], cur[f.name], set[f.name], origins[f.name], i)
+		}
+		merged = next
+	}
+
+	res := make([]FieldOrigin, 0)
+	for _, f := range fields {
+		switch f.name {
+		case "name", "extends", "requirements", "excluded_requirements", "requirement_presets":
+			continue
+		}
+		for _, fo := range origins[f.name] {
+			if f.name == "resources_presets" && fo.Path != "resources_presets."+resources {
+				continue
+			}
+			res = append(res, fo)
+		}
+	}
+	return res
+}
+
+// explainField returns the origins of the field once the layer is merged, from
+// the JSON values of the field before and after merging the layer, the value
+// the layer sets, and the origins before merging the layer. The maps and lists
+// of the common config are explained key by key and item by item, and the
+// other fields as a whole.
+func explainField(f explainedField, prev, cur, set json.RawMessage, origins []FieldOrigin, layer int) []FieldOrigin {
+	if cur == nil {
+		return nil
+	}
+	// A value is set by the layer if the layer sets it as it is merged, or if
+	// the layer changes it in any other way.
+	setBy := func(prev, cur, set json.RawMessage, origin int) int {
+		if bytes.Equal(set, cur) || !bytes.Equal(prev, cur) {
+			return layer
+		}
+		return origin
+	}
+
+	res := make([]FieldOrigin, 0)
+	switch {
+	case f.common && f.kind == reflect.Map:
+		var prevMap, curMap, setMap map[string]json.RawMessage
+		_ = json.Unmarshal(prev, &prevMap)
+		_ = json.Unmarshal(cur, &curMap)
+		_ = json.Unmarshal(set, &setMap)
+		layers := map[string]int{}
+		for _, fo := range origins {
+			layers[fo.Path] = fo.Layer
+		}
+		keys := make([]string, 0, len(curMap))
+		for k := range curMap {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			path := f.name + "." + k
+			res = append(res, FieldOrigin{
+				Path: path, Value: string(curMap[k]), Layer: setBy(prevMap[k], curMap[k], setMap[k], layers[path]),
+			})
+		}
+	case f.common && f.kind == reflect.Slice:
+		var prevItems, curItems []json.RawMessage
+		_ = json.Unmarshal(prev, &prevItems)
+		_ = json.Unmarshal(cur, &curItems)
+		// The items are kept if the list is appended to, and are all set by
+		// the layer if it replaces the list.
+		kept := len(prevItems) <= len(curItems) && !bytes.Equal(set, cur)
+		for j := 0; kept && j < len(prevItems); j++ {
+			kept = bytes.Equal(prevItems[j], curItems[j])
+		}
+		for j, item := range curItems {
+			fo := FieldOrigin{Path: fmt.Sprintf("%s[%d]", f.name, j), Value: string(item), Layer: layer}
+			if kept && j < len(prevItems) && j < len(origins) {
+				fo.Layer = origins[j].Layer
+			}
+			res = append(res, fo)
+		}
+	default:
+		origin := layer
+		if len(origins) != 0 {
+			origin = origins[0].Layer
+		}
+		res = append(res, FieldOrigin{Path: f.name, Value: string(cur), Layer: setBy(prev, cur, set, origin)})
+	}
+	return res
+}
+
+// explainRequirements explains the requirements and the excluded requirements
+// of the job.
+func explainRequirements(layers []ExplainLayer, job spec.Job) []RequirementOrigin {
+	names := make([]string, 0)
+	seen := sets.NewString()
+	archRequirements := sets.NewString()
+	add := func(requirements []string) {
+		for _, name := range requirements {
+			if !seen.Has(name) {
+				seen.Insert(name)
+				names = append(names, name)
+			}
+		}
+	}
+	add(job.Requirements)
+	add(job.ExcludedRequirements)
+	for _, layer := range layers {
+		if layer.Kind == LayerArchitecture {
+			add(layer.common.Requirements)
+			add(layer.common.ExcludedRequirements)
+			archRequirements.Insert(layer.common.Requirements...)
+			archRequirements.Insert(layer.common.ExcludedRequirements...)
+		}
+	}
+
+	// explain explains the requirement for the architecture, or for all of
+	// them if arch is empty.
+	explain := func(name, arch string) RequirementOrigin {
+		r := RequirementOrigin{Name: name, Architecture: arch, Preset: -1}
+		for i, layer := range layers {
+			if layer.Kind == LayerArchitecture && layer.Architecture != arch {
+				continue
+			}
+			if sets.NewString(layer.common.Requirements...).Has(name) {
+				r.RequiredBy = append(r.RequiredBy, i)
+			}
+			if sets.NewString(layer.common.ExcludedRequirements...).Has(name) {
+				r.ExcludedBy = append(r.ExcludedBy, i)
+			}
+			if _, ok := layer.common.RequirementPresets[name]; ok {
+				r.Preset = i
+			}
+		}
+		return r
+	}
+	res := make([]RequirementOrigin, 0, len(names))
+	for _, name := range names {
+		if !archRequirements.Has(name) {
+			res = append(res, explain(name, ""))
+			continue
+		}
+		for _, arch := range jobArchitectures(job) {
+			res = append(res, explain(name, arch))
+		}
+	}
+	return res
+}
+
+// explainArchLayers returns the architecture layers of the job, for the
+// architectures whose config changes the requirements of the job.
+func explainArchLayers(job spec.Job) []ExplainLayer {
+	res := make([]ExplainLayer, 0)
+	for _, arch := range jobArchitectures(job) {
+		ac := job.ArchitectureConfigs[arch]
+		if len(ac.Requirements) == 0 && len(ac.ExcludedRequirements) == 0 {
+			continue
+		}
+		res = append(res, ExplainLayer{
+			Kind:         LayerArchitecture,
+			Architecture: arch,
+			common: spec.CommonConfig{
+				Requirements:         ac.Requirements,
+				ExcludedRequirements: ac.ExcludedRequirements,
+			},
+		})
+	}
+	return res
+}
+
+// jobArchitectures returns the architectures the job is generated for.
+func jobArchitectures(job spec.Job) []string {
+	if len(job.Architectures) == 0 {
+		return []string{ArchAMD64}
+	}
+	return job.Architectures
+}
+
+// String returns the human readable form of the explanation, where the layers
+// are referenced by their indexes.
+func (e Explanation) String() string {
+	sb := &strings.Builder{}
+	w := tabwriter.NewWriter(sb, 0, 0, 2, ' ', 0)
+	layerRefs := func(indexes []int) string {
+		refs := make([]string, 0, len(indexes))
+		for _, i := range indexes {
+			refs = append(refs, fmt.Sprintf("[%d]", i))
+		}
+		return strings.Join(refs, ", ")
+	}
+
+	fmt.Fprintf(w, "Job %q of %s/%s in %s\n", e.Job, e.Org, e.Repo, e.File)
+	fmt.Fprintf(w, "Branches: %s\n", strings.Join(e.Branches, ", "))
+	fmt.Fprintf(w, "Layers:\n")
+	for i, l := range e.Layers {
+		desc := make([]string, 0)
+		if l.File != "" {
+			desc = append(desc, l.File)
+		}
+		if l.Branch != "" {
+			desc = append(desc, "branch "+l.Branch)
+		}
+		if l.Job != "" {
+			desc = append(desc, fmt.Sprintf("job %q", l.Job))
+		}
+		if l.Architecture != "" {
+			desc = append(desc, l.Architecture)
+		}
+		fmt.Fprintf(w, "  [%d]\t%s\t%s\n", i, l.Kind, strings.Join(desc, ", "))
+	}
+	fmt.Fprintf(w, "Fields:\n")
+	for _, f := range e.Fields {
+		fmt.Fprintf(w, "  %s\t%s\t[%d]\n", f.Path, f.Value, f.Layer)
+	}
+	fmt.Fprintf(w, "Requirements:\n")
+	for _, r := range e.Requirements {
+		status := "applied"
+		if !r.Applied() {
+			status = "excluded"
+		}
+		reasons := make([]string, 0)
+		if len(r.RequiredBy) != 0 {
+			reasons = append(reasons, "required by "+layerRefs(r.RequiredBy))
+		}
+		if len(r.ExcludedBy) != 0 {
+			reasons = append(reasons, "excluded by "+layerRefs(r.ExcludedBy))
+		}
+		if r.Preset != -1 {
+			reasons = append(reasons, "preset from "+layerRefs([]int{r.Preset}))
+		} else {
+			reasons = append(reasons, "preset not defined")
+		}
+		name := r.Name
+		if r.Architecture != "" {
+			name += " (" + r.Architecture + ")"
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", name, status, strings.Join(reasons, ", "))
+	}
+	fmt.Fprintf(w, "Prow jobs:\n")
+	for _, pj := range e.ProwJobs {
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", pj.Branch, pj.Type, pj.Name)
+	}
+	_ = w.Flush()
+	return sb.String()
+}
@@ -0,0 +1,289 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExplain(t *testing.T) {
+	bc, err := ReadBase(nil, "testdata/.base.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read the base config: %v", err)
+	}
+	cli := &Client{BaseConfig: bc}
+
+	// The job can be found by the name of a Prow job it generates.
+	explanations, err := cli.Explain([]string{"testdata/.base.yaml"}, "testdata/extends.yaml", "integ-security-ipv6_istio_postsubmit")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(explanations) != 1 {
+		t.Fatalf("Expected 1 explanation, got %d", len(explanations))
+	}
+	e := explanations[0]
+
+	layers := make([]string, 0)
+	for _, l := range e.Layers {
+		layers = append(layers, l.Kind+" "+l.Job)
+	}
+	wantLayers := []string{"base ", "file ", "extends integ", "extends integ-security", "job integ-security-ipv6"}
+	if diff := cmp.Diff(wantLayers, layers); diff != "" {
+		t.Fatalf("Layers do not match, (-want, +got): \n%s", diff)
+	}
+
+	fields := map[string]int{}
+	for _, f := range e.Fields {
+		fields[f.Path] = f.Layer
+	}
+	wantFields := map[string]int{
+		"command":                   2,
+		"args":                      3,
+		"types":                     4,
+		"image":                     1,
+		"node_selector.testing":     0,
+		"resources_presets.default": 0,
+		"env[0]":                    0,
+		"env[1]":                    2,
+		"env[2]":                    3,
+		"env[3]":                    4,
+	}
+	for path, layer := range wantFields {
+		if got, ok := fields[path]; !ok || got != layer {
+			t.Errorf("Field %s: want layer %d, got %d (found: %v)", path, layer, got, ok)
+		}
+	}
+
+	wantRequirements := []RequirementOrigin{
+		{Name: "cache", RequiredBy: []int{0}, Preset: 0},
+		{Name: "kind", RequiredBy: []int{2}, Preset: 0},
+		{Name: "gcp", RequiredBy: []int{4}, Preset: 0},
+	}
+	if diff := cmp.Diff(wantRequirements, e.Requirements); diff != "" {
+		t.Fatalf("Requirements do not match, (-want, +got): \n%s", diff)
+	}
+
+	wantProwJobs := []ExplainedProwJob{
+		{Branch: "master", Type: TypePresubmit, Name: "integ-security-ipv6_istio"},
+		{Branch: "master", Type: TypePostsubmit, Name: "integ-security-ipv6_istio_postsubmit"},
+	}
+	if diff := cmp.Diff(wantProwJobs, e.ProwJobs); diff != "" {
+		t.Fatalf("Prow jobs do not match, (-want, +got): \n%s", diff)
+	}
+}
+
+func TestExplainExcludedRequirements(t *testing.T) {
+	bc, err := ReadBase(nil, "testdata/.base.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read the base config: %v", err)
+	}
+	cli := &Client{BaseConfig: bc}
+
+	explanations, err := cli.Explain([]string{"testdata/.base.yaml"}, "testdata/simple.yaml", "presubmit-kind")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(explanations) != 1 {
+		t.Fatalf("Expected 1 explanation, got %d", len(explanations))
+	}
+	for _, r := range explanations[0].Requirements {
+		if r.Name == "cache" && r.Applied() {
+			t.Fatalf("Expected the cache requirement to be excluded, got %+v", r)
+		}
+	}
+}
+
+func TestExplainArchitectures(t *testing.T) {
+	bc, err := ReadBase(nil, "testdata/.base.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read the base config: %v", err)
+	}
+	cli := &Client{BaseConfig: bc}
+
+	for _, tt := range []struct {
+		job          string
+		layers       []string
+		requirements []RequirementOrigin
+	}{
+		{
+			// kind is excluded by the config of arm64 only.
+			job:    "integ",
+			layers: []string{"base ", "file ", "job ", "architecture arm64"},
+			requirements: []RequirementOrigin{
+				{Name: "cache", RequiredBy: []int{0}, Preset: 0},
+				{Name: "kind", Architecture: ArchAMD64, RequiredBy: []int{2}, Preset: 0},
+				{Name: "kind", Architecture: ArchARM64, RequiredBy: []int{2}, ExcludedBy: []int{3}, Preset: 0},
+			},
+		},
+		{
+			job:    "build",
+			layers: []string{"base ", "file ", "job ", "architecture s390x"},
+			requirements: []RequirementOrigin{
+				{Name: "cache", RequiredBy: []int{0}, Preset: 0},
+				{Name: "docker", Architecture: "s390x", RequiredBy: []int{3}, Preset: 0},
+			},
+		},
+	} {
+		t.Run(tt.job, func(t *testing.T) {
+			explanations, err := cli.Explain([]string{"testdata/.base.yaml"}, "testdata/architectures.yaml", tt.job)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(explanations) != 1 {
+				t.Fatalf("Expected 1 explanation, got %d", len(explanations))
+			}
+			e := explanations[0]
+			layers := make([]string, 0)
+			for _, l := range e.Layers {
+				layers = append(layers, l.Kind+" "+l.Architecture)
+			}
+			if diff := cmp.Diff(tt.layers, layers); diff != "" {
+				t.Fatalf("Layers do not match, (-want, +got): \n%s", diff)
+			}
+			if diff := cmp.Diff(tt.requirements, e.Requirements); diff != "" {
+				t.Fatalf("Requirements do not match, (-want, +got): \n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExplainTopLevelFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		".base.yaml": `env:
+- name: A
+  value: a
+`,
+		"istio.yaml": `org: istio
+repo: istio
+image: gcr.io/istio-testing/build-tools:latest
+jobs:
+- name: unit
+  command: [make, test]
+`,
+		"private/.base.yaml": `env:
+- name: B
+  value: b
+`,
+	})
+	baseFiles := BaseFiles(dir, dir)
+	// The base config of the input directory is only read once.
+	if diff := cmp.Diff([]string{filepath.Join(dir, ".base.yaml")}, baseFiles); diff != "" {
+		t.Fatalf("Base files do not match, (-want, +got): \n%s", diff)
+	}
+	wantNested := []string{filepath.Join(dir, ".base.yaml"), filepath.Join(dir, "private", ".base.yaml")}
+	if diff := cmp.Diff(wantNested, BaseFiles(dir, filepath.Join(dir, "private"))); diff != "" {
+		t.Fatalf("Base files of the nested directory do not match, (-want, +got): \n%s", diff)
+	}
+
+	bc, err := ReadBase(nil, filepath.Join(dir, ".base.yaml"))
+	if err != nil {
+		t.Fatalf("Failed to read the base config: %v", err)
+	}
+	cli := &Client{BaseConfig: bc}
+	explanations, err := cli.Explain(baseFiles, filepath.Join(dir, "istio.yaml"), "unit")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(explanations) != 1 {
+		t.Fatalf("Expected 1 explanation, got %d", len(explanations))
+	}
+	var env []FieldOrigin
+	for _, f := range explanations[0].Fields {
+		if f.Path == "env[0]" || f.Path == "env[1]" {
+			env = append(env, f)
+		}
+	}
+	want := []FieldOrigin{{Path: "env[0]", Value: `{"name":"A","value":"a"}`, Layer: 0}}
+	if diff := cmp.Diff(want, env); diff != "" {
+		t.Fatalf("Env does not match, (-want, +got): \n%s", diff)
+	}
+}
+
+func TestExplainBranchOverrides(t *testing.T) {
+	bc, err := ReadBase(nil, "testdata/.base.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read the base config: %v", err)
+	}
+	cli := &Client{BaseConfig: bc}
+
+	// The override of the release branch is explained separately.
+	explanations, err := cli.Explain([]string{"testdata/.base.yaml"}, "testdata/branch-overrides.yaml", "unit")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	branches := make([][]string, 0)
+	for _, e := range explanations {
+		branches = append(branches, e.Branches)
+	}
+	if diff := cmp.Diff([][]string{{"master"}, {"release-1.14"}}, branches); diff != "" {
+		t.Fatalf("Branches do not match, (-want, +got): \n%s", diff)
+	}
+
+	explanations, err = cli.Explain([]string{"testdata/.base.yaml"}, "testdata/branch-overrides.yaml", "unit_istio_release-1.14")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(explanations) != 1 {
+		t.Fatalf("Expected 1 explanation, got %d", len(explanations))
+	}
+	e := explanations[0]
+	layers := make([]string, 0)
+	for _, l := range e.Layers {
+		layers = append(layers, l.Kind+" "+l.Branch+" "+l.Job)
+	}
+	wantLayers := []string{"base  ", "file  ", "job  unit", "override release-1.14 ", "override release-1.14 unit"}
+	if diff := cmp.Diff(wantLayers, layers); diff != "" {
+		t.Fatalf("Layers do not match, (-want, +got): \n%s", diff)
+	}
+	fields := map[string]FieldOrigin{}
+	for _, f := range e.Fields {
+		fields[f.Path] = f
+	}
+	wantFields := map[string]FieldOrigin{
+		"command": {Path: "command", Value: `["make","test"]`, Layer: 2},
+		"args":    {Path: "args", Value: `["--legacy"]`, Layer: 4},
+		"image":   {Path: "image", Value: `"gcr.io/istio-testing/build-tools:release-1.14"`, Layer: 3},
+		"env[1]":  {Path: "env[1]", Value: `{"name":"RELEASE","value":"1.14"}`, Layer: 3},
+	}
+	for path, want := range wantFields {
+		if diff := cmp.Diff(want, fields[path]); diff != "" {
+			t.Errorf("Field %s does not match, (-want, +got): \n%s", path, diff)
+		}
+	}
+	wantRequirements := []RequirementOrigin{
+		{Name: "cache", RequiredBy: []int{0}, Preset: 0},
+		{Name: "gcp", RequiredBy: []int{4}, Preset: 0},
+	}
+	if diff := cmp.Diff(wantRequirements, e.Requirements); diff != "" {
+		t.Fatalf("Requirements do not match, (-want, +got): \n%s", diff)
+	}
+	wantProwJobs := []ExplainedProwJob{{Branch: "release-1.14", Type: TypePresubmit, Name: "unit_istio_release-1.14"}}
+	if diff := cmp.Diff(wantProwJobs, e.ProwJobs); diff != "" {
+		t.Fatalf("Prow jobs do not match, (-want, +got): \n%s", diff)
+	}
+
+	// The job excluded for the release branch is only explained for master.
+	explanations, err = cli.Explain([]string{"testdata/.base.yaml"}, "testdata/branch-overrides.yaml", "lint")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(explanations) != 1 || !cmp.Equal([]string{"master"}, explanations[0].Branches) {
+		t.Fatalf("Expected the lint job to be explained for master only, got %d explanations", len(explanations))
+	}
+}
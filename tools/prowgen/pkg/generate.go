@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"regexp"
 	"sort"
 	"strings"
 	"time"
@@ -46,6 +47,9 @@ const (
 	ArchAMD64 = "amd64"
 	ArchARM64 = "arm64"
 
+	HostTypeGitHub = "github"
+	HostTypeGerrit = "gerrit"
+
 	TypePostsubmit = "postsubmit"
 	TypePresubmit  = "presubmit"
 	TypePeriodic   = "periodic"
@@ -58,43 +62,90 @@ type Client struct {
 	BaseConfig spec.BaseConfig
 
 	LongJobNamesAllowed bool
+	// EnforcePolicies checks the generated Prow jobs against the policies of
+	// the base config, and fails the conversion on violations.
+	EnforcePolicies bool
 }
 
-func ReadBase(baseConfig *spec.BaseConfig, file string) spec.BaseConfig {
-	yamlFile, err := ioutil.ReadFile(file)
+func ReadBase(baseConfig *spec.BaseConfig, file string) (spec.BaseConfig, error) {
+	newBaseConfig, err := ReadRawBase(file)
 	if err != nil {
-		log.Fatalf("Failed to read %q: %v", file, err)
+		return spec.BaseConfig{}, err
+	}
+	if len(newBaseConfig.Imports) != 0 {
+		imported, err := readImports(file, newBaseConfig.Imports)
+		if err != nil {
+			return spec.BaseConfig{}, err
+		}
+		newBaseConfig.CommonConfig = mergeCommonConfig(imported, newBaseConfig.CommonConfig)
+		newBaseConfig.Imports = importPaths(file, newBaseConfig.Imports)
 	}
-	newBaseConfig := spec.BaseConfig{}
-	if err := yaml.UnmarshalStrict(yamlFile, &newBaseConfig, yaml.DisallowUnknownFields); err != nil {
-		log.Fatalf("Failed to unmarshal %q: %v", file, err)
+	if err := validatePolicies(file, newBaseConfig.Policies); err != nil {
+		return spec.BaseConfig{}, err
 	}
 	if baseConfig == nil {
-		return newBaseConfig
+		return newBaseConfig, nil
 	}
 
 	mergedBaseConfig := baseConfig.DeepCopy()
 	mergedBaseConfig.CommonConfig = mergeCommonConfig(mergedBaseConfig.CommonConfig, newBaseConfig.CommonConfig)
+	mergedBaseConfig.Imports = newBaseConfig.Imports
+	mergedBaseConfig.Policies = mergePolicies(mergedBaseConfig.Policies, newBaseConfig.Policies)
 
-	return mergedBaseConfig
+	return mergedBaseConfig, nil
 }
 
-// Reads the jobs yaml
-func (cli *Client) ReadJobsConfig(file string) spec.JobsConfig {
+// ReadRawBase reads the base config yaml as it's written, without the imports
+// and the parent base config.
+func ReadRawBase(file string) (spec.BaseConfig, error) {
+	yamlFile, err := ioutil.ReadFile(file)
+	if err != nil {
+		return spec.BaseConfig{}, &ValidationError{File: file, Message: fmt.Sprintf("failed to read: %v", err)}
+	}
+	baseConfig := spec.BaseConfig{}
+	if err := yaml.UnmarshalStrict(yamlFile, &baseConfig, yaml.DisallowUnknownFields); err != nil {
+		return spec.BaseConfig{}, yamlError(file, err)
+	}
+	return baseConfig, nil
+}
+
+// ReadRawJobsConfig reads the jobs yaml as it's written, without applying the
+// base config and resolving the extends.
+func ReadRawJobsConfig(file string) (spec.JobsConfig, error) {
 	yamlFile, err := ioutil.ReadFile(file)
 	if err != nil {
-		log.Fatalf("Failed to read %q: %v", file, err)
+		return spec.JobsConfig{}, &ValidationError{File: file, Message: fmt.Sprintf("failed to read: %v", err)}
 	}
 	jobsConfig := spec.JobsConfig{}
 	if err := yaml.UnmarshalStrict(yamlFile, &jobsConfig); err != nil {
-		log.Fatalf("Failed to unmarshal %q: %v", file, err)
+		return spec.JobsConfig{}, yamlError(file, err)
 	}
+	return jobsConfig, nil
+}
 
+// Reads the jobs yaml
+func (cli *Client) ReadJobsConfig(file string) (spec.JobsConfig, error) {
+	jobsConfig, err := ReadRawJobsConfig(file)
+	if err != nil {
+		return spec.JobsConfig{}, err
+	}
+	return cli.resolveJobsConfig(file, jobsConfig)
+}
+
+// resolveJobsConfig applies the base config to the jobs config as it's written
+// in the file, and resolves the extends.
+func (cli *Client) resolveJobsConfig(file string, jobsConfig spec.JobsConfig) (spec.JobsConfig, error) {
 	if len(jobsConfig.Branches) == 0 {
 		jobsConfig.Branches = []string{"master"}
 	}
 
-	return resolveOverwrites(cli.BaseConfig.CommonConfig.DeepCopy(), jobsConfig)
+	jobs, err := resolveExtends(file, jobsConfig.Jobs)
+	if err != nil {
+		return spec.JobsConfig{}, err
+	}
+	jobsConfig.Jobs = jobs
+
+	return resolveOverwrites(cli.BaseConfig.CommonConfig.DeepCopy(), jobsConfig), nil
 }
 
 func deepCopyMap(mp map[string]string) map[string]string {
@@ -121,6 +172,26 @@ func mergeCommonConfig(configs ...spec.CommonConfig) spec.CommonConfig {
 		if len(configs[i].NodeSelector) != 0 {
 			mergedCommonConfig.NodeSelector = deepCopyMap(configs[i].NodeSelector)
 		}
+		// The other pod scheduling and networking fields are replaced the same
+		// way, since merging e.g. the tolerations or the affinity terms of
+		// different node pools would match none of them.
+		if len(configs[i].Tolerations) != 0 {
+			mergedCommonConfig.Tolerations = config.Tolerations
+		}
+		if configs[i].Affinity != nil {
+			mergedCommonConfig.Affinity = config.Affinity
+		}
+		if len(configs[i].HostAliases) != 0 {
+			mergedCommonConfig.HostAliases = config.HostAliases
+		}
+		if configs[i].DNSConfig != nil {
+			mergedCommonConfig.DNSConfig = config.DNSConfig
+		}
+		// SecurityContext is also a special case, since merging it field by field
+		// would make it impossible to drop privileges set in a parent layer.
+		if configs[i].SecurityContext != nil {
+			mergedCommonConfig.SecurityContext = config.SecurityContext
+		}
 	}
 	return mergedCommonConfig
 }
@@ -137,65 +208,339 @@ func resolveOverwrites(baseCommonConfig spec.CommonConfig, jobsConfig spec.JobsC
 	return jobsConfig
 }
 
+// resolveExtends replaces each job that extends another job with the result of
+// merging it on top of the (resolved) parent job.
+func resolveExtends(fileName string, jobs []spec.Job) ([]spec.Job, error) {
+	indexes := map[string][]int{}
+	for i, job := range jobs {
+		indexes[job.Name] = append(indexes[job.Name], i)
+	}
+
+	resolved := make([]*spec.Job, len(jobs))
+	var resolve func(i int, chain []string) (spec.Job, error)
+	resolve = func(i int, chain []string) (spec.Job, error) {
+		if resolved[i] != nil {
+			return *resolved[i], nil
+		}
+		job := jobs[i]
+		for j, name := range chain {
+			if name == job.Name {
+				return spec.Job{}, jobError(fileName, job.Name, "extends cycle: %s",
+					strings.Join(append(chain[j:], job.Name), " -> "))
+			}
+		}
+		if job.Extends != "" {
+			parents := indexes[job.Extends]
+			if len(parents) == 0 {
+				return spec.Job{}, jobError(fileName, job.Name, "extends nonexistent job %q", job.Extends)
+			} else if len(parents) > 1 {
+				return spec.Job{}, jobError(fileName, job.Name, "extends ambiguous job %q, which is defined %d times",
+					job.Extends, len(parents))
+			}
+			parent, err := resolve(parents[0], append(chain, job.Name))
+			if err != nil {
+				return spec.Job{}, err
+			}
+			job = mergeJob(parent, job)
+		}
+		resolved[i] = &job
+		return job, nil
+	}
+
+	res := make([]spec.Job, 0, len(jobs))
+	for i := range jobs {
+		job, err := resolve(i, nil)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, job)
+	}
+	return res, nil
+}
+
+// mergeJob overlays the child job on the parent job. The common config is
+// merged with mergeCommonConfig, and all the other fields are inherited from the
+// parent job only if they are not set in the child job.
+func mergeJob(parent, child spec.Job) spec.Job {
+	merged := child.DeepCopy()
+	merged.CommonConfig = mergeCommonConfig(parent.CommonConfig, child.CommonConfig)
+	merged.Extends = ""
+
+	inherited := parent.DeepCopy()
+	inherited.CommonConfig = spec.CommonConfig{}
+	inherited.Name = ""
+	inherited.Extends = ""
+	if err := mergo.Merge(&merged, inherited); err != nil {
+		log.Fatalf("Failed to merge job %q into %q: %v", parent.Name, child.Name, err)
+	}
+	return merged
+}
+
 // FilterReleaseBranchingJobs filters then returns jobs with release branching enabled.
+// The needs of the returned jobs on the filtered out jobs are dropped.
 func FilterReleaseBranchingJobs(jobs []spec.Job) []spec.Job {
-	jobsF := make([]spec.Job, 0)
+	filtered := sets.NewString()
 	for _, j := range jobs {
 		if j.DisableReleaseBranching {
+			filtered.Insert(j.Name)
+		}
+	}
+	return removeJobs(jobs, filtered)
+}
+
+// removeJobs returns the jobs without the ones with the names, and drops the
+// needs of the remaining jobs on them.
+func removeJobs(jobs []spec.Job, names sets.String) []spec.Job {
+	res := make([]spec.Job, 0)
+	for _, j := range jobs {
+		if names.Has(j.Name) {
 			continue
 		}
-		jobsF = append(jobsF, j)
+		if names.HasAny(j.Needs...) {
+			needs := make([]string, 0, len(j.Needs))
+			for _, n := range j.Needs {
+				if !names.Has(n) {
+					needs = append(needs, n)
+				}
+			}
+			j.Needs = needs
+		}
+		res = append(res, j)
 	}
-	return jobsF
+	return res
+}
+
+// validateBranchOverrides checks that the branch overrides of all the branches
+// reference the branches and the jobs of the file.
+func validateBranchOverrides(fileName string, jobsConfig spec.JobsConfig) error {
+	var err error
+	names := sets.NewString()
+	for _, job := range jobsConfig.Jobs {
+		names.Insert(job.Name)
+	}
+	for _, branch := range sets.StringKeySet(jobsConfig.BranchOverrides).List() {
+		override := jobsConfig.BranchOverrides[branch]
+		if !sets.NewString(jobsConfig.Branches...).Has(branch) {
+			err = multierror.Append(err, jobError(fileName, "", "branch_overrides for %s, which is not in branches", branch))
+		}
+		for _, patch := range override.Jobs {
+			if !names.Has(patch.Name) {
+				err = multierror.Append(err, jobError(fileName, "", "branch_overrides for %s patches nonexistent job %q", branch, patch.Name))
+			}
+			if patch.Extends != "" {
+				err = multierror.Append(err, jobError(fileName, "", "branch_overrides for %s cannot set extends for job %q", branch, patch.Name))
+			}
+		}
+		for _, name := range override.ExcludedJobs {
+			if !names.Has(name) {
+				err = multierror.Append(err, jobError(fileName, "", "branch_overrides for %s excludes nonexistent job %q", branch, name))
+			}
+		}
+	}
+	return err
+}
+
+// applyBranchOverride returns the jobs config with the override of the branch
+// merged into it, if there is one. The overrides must have been validated.
+func applyBranchOverride(jobsConfig spec.JobsConfig, branch string) spec.JobsConfig {
+	override, ok := jobsConfig.BranchOverrides[branch]
+	if !ok {
+		return jobsConfig
+	}
+
+	jobsConfig.CommonConfig = mergeCommonConfig(jobsConfig.CommonConfig, override.CommonConfig)
+	jobs := make([]spec.Job, 0, len(jobsConfig.Jobs))
+	for _, job := range jobsConfig.Jobs {
+		job.CommonConfig = mergeCommonConfig(job.CommonConfig, override.CommonConfig)
+		for _, patch := range override.Jobs {
+			if patch.Name == job.Name {
+				job = mergeJob(job, patch)
+			}
+		}
+		jobs = append(jobs, job)
+	}
+	jobsConfig.Jobs = removeJobs(jobs, sets.NewString(override.ExcludedJobs...))
+	return jobsConfig
 }
 
 func validateJobsConfig(fileName string, jobsConfig spec.JobsConfig) error {
 	var err error
 	if jobsConfig.Org == "" {
-		err = multierror.Append(err, fmt.Errorf("%s: org must be set", fileName))
+		err = multierror.Append(err, jobError(fileName, "", "org must be set"))
 	}
 	if jobsConfig.Repo == "" {
-		err = multierror.Append(err, fmt.Errorf("%s: repo must be set", fileName))
+		err = multierror.Append(err, jobError(fileName, "", "repo must be set"))
+	}
+	if jobsConfig.HostType != "" {
+		if e := validate(jobsConfig.HostType, sets.NewString(HostTypeGitHub, HostTypeGerrit), "host_type"); e != nil {
+			err = multierror.Append(err, jobError(fileName, "", "%v", e))
+		}
+	}
+
+	if jobsConfig.Matrix != nil {
+		for _, entries := range [][]map[string]string{jobsConfig.Matrix.Exclude, jobsConfig.Matrix.Include} {
+			for _, entry := range entries {
+				for dimension := range entry {
+					if _, ok := jobsConfig.Matrix.Dimensions[dimension]; !ok {
+						err = multierror.Append(err, jobError(fileName, "", "dimension %q of the matrix entry %v is not configured in the matrix", dimension, entry))
+					}
+				}
+			}
+		}
+	}
+
+	if jobsConfig.ScheduleWindow != "" && jobsConfig.Cron != CronAuto {
+		err = multierror.Append(err, jobError(fileName, "", "schedule_window can only be set with cron %s", CronAuto))
+	}
+
+	if e := validateNeeds(fileName, jobsConfig.Jobs); e != nil {
+		err = multierror.Append(err, e)
 	}
 
 	for _, job := range jobsConfig.Jobs {
+		jobErr := func(format string, args ...interface{}) {
+			err = multierror.Append(err, jobError(fileName, job.Name, format, args...))
+		}
 		if job.Image == "" {
-			err = multierror.Append(err, fmt.Errorf("%s: image must be set for job %v", fileName, job.Name))
+			jobErr("image must be set")
 		}
 		if job.Resources != "" {
 			if _, f := jobsConfig.ResourcePresets[job.Resources]; !f {
-				err = multierror.Append(err, fmt.Errorf("%s: job '%v' has nonexistant resource '%v'", fileName, job.Name, job.Resources))
+				jobErr("nonexistent resource '%v'", job.Resources)
+			}
+		}
+		sidecarNames := sets.NewString(decorator.MainContainerName)
+		for _, sidecar := range job.Sidecars {
+			if sidecar.Name == "" {
+				jobErr("name must be set for the sidecars")
+			} else if sidecarNames.Has(sidecar.Name) {
+				jobErr("duplicate container name %q", sidecar.Name)
+			}
+			sidecarNames.Insert(sidecar.Name)
+			if sidecar.Image == "" {
+				jobErr("image must be set for sidecar %q", sidecar.Name)
+			}
+			if sidecar.Resources != "" {
+				if _, f := jobsConfig.ResourcePresets[sidecar.Resources]; !f {
+					jobErr("sidecar %q has nonexistent resource '%v'", sidecar.Name, sidecar.Resources)
+				}
 			}
 		}
 
 		if sets.NewString(job.Types...).Has(TypePeriodic) {
 			if job.Cron != "" && job.Interval != "" {
-				err = multierror.Append(err, fmt.Errorf("%s: cron and interval cannot be both set in periodic %s", fileName, job.Name))
+				jobErr("cron and interval cannot be both set in periodic")
 			} else if job.Cron == "" && job.Interval == "" {
-				err = multierror.Append(err, fmt.Errorf("%s: cron and interval cannot be both empty in periodic %s", fileName, job.Name))
-			} else if job.Cron != "" {
+				jobErr("cron and interval cannot be both empty in periodic")
+			} else if job.Cron != "" && job.Cron != CronAuto {
 				if _, e := cron.Parse(job.Cron); e != nil {
-					err = multierror.Append(err, fmt.Errorf("%s: invalid cron string %s in periodic %s: %v", fileName, job.Cron, job.Name, e))
+					jobErr("invalid cron string %s in periodic: %v", job.Cron, e)
 				}
 			} else if job.Interval != "" {
 				if _, e := time.ParseDuration(job.Interval); e != nil {
-					err = multierror.Append(err, fmt.Errorf("%s: cannot parse duration %s in periodic %s: %v", fileName, job.Interval, job.Name, e))
+					jobErr("cannot parse duration %s in periodic: %v", job.Interval, e)
 				}
 			}
 		}
+		if job.ScheduleWindow != "" {
+			if _, e := parseScheduleWindow(job.ScheduleWindow); e != nil {
+				jobErr("%v", e)
+			}
+		}
+		// The schedule_window inherited from the file is checked with the
+		// cron of the file, so that a job can still set another cron.
+		if job.ScheduleWindow != jobsConfig.ScheduleWindow && job.Cron != CronAuto {
+			jobErr("schedule_window can only be set with cron %s", CronAuto)
+		}
 		for _, t := range job.Types {
 			if e := validate(t, sets.NewString(TypePostsubmit, TypePresubmit, TypePeriodic), "type"); e != nil {
-				err = multierror.Append(err, e)
+				jobErr("%v", e)
+			}
+		}
+		for _, m := range job.Modifiers {
+			// The modifiers referencing the params or matrix are validated
+			// when they are applied.
+			if strings.Contains(m, "$(") {
+				continue
+			}
+			modifier, e := decorator.LookupModifier(m)
+			if e != nil {
+				jobErr("%v", e)
+				continue
+			}
+			types := job.Types
+			if len(types) == 0 {
+				types = []string{TypePresubmit, TypePostsubmit}
+			}
+			applies := false
+			for _, t := range types {
+				applies = applies || (t == TypePresubmit && modifier.Presubmit != nil) ||
+					(t == TypePostsubmit && modifier.Postsubmit != nil) ||
+					(t == TypePeriodic && modifier.Periodic != nil)
+			}
+			if !applies {
+				jobErr("modifier %q has no effect on %v jobs", m, types)
+			}
+		}
+		architectures := sets.NewString(ArchAMD64, ArchARM64)
+		for _, arch := range sets.StringKeySet(job.ArchitectureConfigs).List() {
+			architectures.Insert(arch)
+			if ac := job.ArchitectureConfigs[arch]; ac.Resources != "" {
+				if _, f := jobsConfig.ResourcePresets[ac.Resources]; !f {
+					jobErr("architecture %q has nonexistent resource '%v'", arch, ac.Resources)
+				}
 			}
 		}
 		for _, t := range job.Architectures {
-			if e := validate(t, sets.NewString(ArchAMD64, ArchARM64, TypePeriodic), "architectures"); e != nil {
-				err = multierror.Append(err, e)
+			if e := validate(t, architectures, "architectures"); e != nil {
+				jobErr("%v", e)
+			}
+		}
+		if len(job.RunIfChanged) != 0 && len(job.SkipIfOnlyChanged) != 0 {
+			jobErr("run_if_changed and skip_if_only_changed cannot be both set")
+		}
+		if job.Regex != "" && (len(job.RunIfChanged) != 0 || len(job.SkipIfOnlyChanged) != 0) {
+			jobErr("regex cannot be set together with run_if_changed or skip_if_only_changed")
+		}
+		if job.Regex != "" && !strings.Contains(job.Regex, "$(") {
+			if _, e := regexp.Compile(job.Regex); e != nil {
+				jobErr("invalid regex %q: %v", job.Regex, e)
+			}
+		}
+		if len(job.SkipIfOnlyChanged) != 0 && sets.NewString(job.Modifiers...).Has(decorator.ModifierSkipIfOnlyChanged) {
+			jobErr("modifier %q cannot be used with skip_if_only_changed", decorator.ModifierSkipIfOnlyChanged)
+		}
+		for _, name := range append(append([]string{}, job.RunIfChanged...), job.SkipIfOnlyChanged...) {
+			paths, f := job.Paths[name]
+			if !f {
+				jobErr("nonexistent path set %q", name)
+			} else if len(paths) == 0 {
+				jobErr("path set %q is empty", name)
+			}
+			for _, p := range paths {
+				// The paths referencing the params or matrix are validated
+				// by Prow once they are resolved.
+				if strings.Contains(p, "$(") {
+					continue
+				}
+				if _, e := regexp.Compile(p); e != nil {
+					jobErr("invalid regex %q in path set %q: %v", p, name, e)
+				}
+			}
+		}
+		switch jobsConfig.HostType {
+		case HostTypeGerrit:
+			if job.Trigger != "" {
+				jobErr("trigger is only supported for GitHub repos")
+			}
+		case HostTypeGitHub:
+			if job.GerritPresubmitLabel != "" || job.GerritPostsubmitLabel != "" {
+				jobErr("gerrit labels are only supported for Gerrit repos")
 			}
 		}
 		for _, repo := range job.Repos {
 			if len(strings.Split(repo, "/")) != 2 {
-				err = multierror.Append(err, fmt.Errorf("%s: repo %v not valid, should take form org/repo", fileName, repo))
+				jobErr("repo %v not valid, should take form org/repo", repo)
 			}
 		}
 	}
@@ -209,152 +554,95 @@ func (cli *Client) ConvertJobConfig(fileName string, jobsConfig spec.JobsConfig,
 		PostsubmitsStatic: map[string][]config.Postsubmit{},
 		Periodics:         []config.Periodic{},
 	}
+	if err := validateBranchOverrides(fileName, jobsConfig); err != nil {
+		return output, err
+	}
+	jobsConfig = applyBranchOverride(jobsConfig, branch)
 	if err := validateJobsConfig(fileName, jobsConfig); err != nil {
 		return output, err
 	}
 
-	baseConfig := cli.BaseConfig
-	testgridConfig := baseConfig.TestgridConfig
-
+	var err error
 	var presubmits []config.Presubmit
 	var postsubmits []config.Postsubmit
 	var periodics []config.Periodic
+	// The names of the jobs each Prow job is generated from.
+	var presubmitParents, postsubmitParents, periodicParents []string
 
 	for _, parentJob := range jobsConfig.Jobs {
 		if len(parentJob.Architectures) == 0 {
 			parentJob.Architectures = []string{ArchAMD64}
 		}
+		// All the errors are reported against the job defined in the meta
+		// config file, since the expanded jobs do not exist there.
+		jobErr := func(e error) {
+			err = multierror.Append(err, wrapJobErrors(fileName, parentJob.Name, e))
+		}
 
-		expandedJobs := decorator.ApplyVariables(parentJob, parentJob.Architectures, jobsConfig.Params, jobsConfig.Matrix, cli.BaseConfig.ClusterOverrides)
+		// The needs reference the jobs as they are defined in the file, so
+		// the matrix dimensions in them must not expand the job.
+		hasNeeds := len(parentJob.Needs) != 0
+		parentJob.Needs = nil
+		expandedJobs, e := decorator.ApplyVariables(parentJob, parentJob.Architectures, jobsConfig.Params, jobsConfig.Matrix,
+			map[string]string{
+				decorator.VariableBranch: branch,
+				decorator.VariableOrg:    jobsConfig.Org,
+				decorator.VariableRepo:   jobsConfig.Repo,
+			}, cli.BaseConfig.ClusterOverrides)
+		if e != nil {
+			jobErr(e)
+			continue
+		}
 		for _, job := range expandedJobs {
-			brancher := config.Brancher{
-				Branches: []string{fmt.Sprintf("^%s$", branch)},
-			}
-
-			testgridJobPrefix := jobsConfig.Org
-			if branch != "master" {
-				testgridJobPrefix += "_" + branch
-			}
-			testgridJobPrefix += "_" + jobsConfig.Repo
-
 			if len(job.Types) == 0 || sets.NewString(job.Types...).Has(TypePresubmit) {
-				name := fmt.Sprintf("%s_%s", job.Name, jobsConfig.Repo)
-				if branch != "master" {
-					name += "_" + branch
-				}
-
-				base, err := cli.createJobBase(baseConfig, jobsConfig, job, name, branch, jobsConfig.ResourcePresets)
-				if err != nil {
-					return output, err
-				}
-
-				presubmit := config.Presubmit{
-					JobBase:   base,
-					AlwaysRun: true,
-					Brancher:  brancher,
-				}
-				if job.GerritPresubmitLabel != "" {
-					presubmit.Labels[client.GerritReportLabel] = job.GerritPresubmitLabel
-				}
-				if pa, ok := baseConfig.PathAliases[jobsConfig.Org]; ok {
-					presubmit.UtilityConfig.PathAlias = fmt.Sprintf("%s/%s", pa, jobsConfig.Repo)
-				}
-				if job.Regex != "" {
-					presubmit.RegexpChangeMatcher = config.RegexpChangeMatcher{
-						RunIfChanged: job.Regex,
+				if presubmit, e := cli.createPresubmit(jobsConfig, job, branch); e != nil {
+					jobErr(e)
+				} else {
+					if hasNeeds {
+						// The downstream trigger runs it after the jobs it
+						// needs succeeded, so Prow must not trigger it.
+						presubmit.AlwaysRun = false
 					}
-					presubmit.AlwaysRun = false
-				}
-				if job.Trigger != "" {
-					defaultTrigger := config.DefaultTriggerFor(presubmit.JobBase.Name)
-					// Match the default trigger + the new trigger.
-					presubmit.Trigger = fmt.Sprintf("(%s)|((?m)^%s(\\s+|$))", defaultTrigger, job.Trigger)
-				}
-				if testgridConfig.Enabled {
-					if err := mergo.Merge(&presubmit.JobBase.Annotations, map[string]string{
-						TestGridDashboard: testgridJobPrefix,
-					}); err != nil {
-						return output, err
+					if cli.EnforcePolicies {
+						if e := cli.checkPolicies(jobsConfig, job, TypePresubmit, branch, presubmit.JobBase); e != nil {
+							jobErr(e)
+						}
 					}
+					presubmits = append(presubmits, presubmit)
+					presubmitParents = append(presubmitParents, parentJob.Name)
 				}
-				decorator.ApplyModifiersPresubmit(&presubmit, job.Modifiers)
-				decorator.ApplyRequirements(&presubmit.JobBase, job.Requirements, job.ExcludedRequirements, jobsConfig.RequirementPresets)
-				presubmits = append(presubmits, presubmit)
 			}
 
 			if len(job.Types) == 0 || sets.NewString(job.Types...).Has(TypePostsubmit) {
-				name := fmt.Sprintf("%s_%s", job.Name, jobsConfig.Repo)
-				if branch != "master" {
-					name += "_" + branch
-				}
-				name += "_postsubmit"
-
-				base, err := cli.createJobBase(baseConfig, jobsConfig, job, name, branch, jobsConfig.ResourcePresets)
-				if err != nil {
-					return output, err
-				}
-
-				postsubmit := config.Postsubmit{
-					JobBase:  base,
-					Brancher: brancher,
-				}
-				if job.GerritPostsubmitLabel != "" {
-					postsubmit.Labels[client.GerritReportLabel] = job.GerritPostsubmitLabel
-				}
-				if pa, ok := baseConfig.PathAliases[jobsConfig.Org]; ok {
-					postsubmit.UtilityConfig.PathAlias = fmt.Sprintf("%s/%s", pa, jobsConfig.Repo)
-				}
-				if job.Regex != "" {
-					postsubmit.RegexpChangeMatcher = config.RegexpChangeMatcher{
-						RunIfChanged: job.Regex,
+				if postsubmit, e := cli.createPostsubmit(jobsConfig, job, branch); e != nil {
+					jobErr(e)
+				} else {
+					if hasNeeds {
+						alwaysRun := false
+						postsubmit.AlwaysRun = &alwaysRun
 					}
-				}
-				if testgridConfig.Enabled {
-					if err := mergo.Merge(&postsubmit.JobBase.Annotations, map[string]string{
-						TestGridDashboard:   testgridJobPrefix + "_postsubmit",
-						TestGridAlertEmail:  testgridConfig.AlertEmail,
-						TestGridNumFailures: testgridConfig.NumFailuresToAlert,
-					}); err != nil {
-						return output, err
+					if cli.EnforcePolicies {
+						if e := cli.checkPolicies(jobsConfig, job, TypePostsubmit, branch, postsubmit.JobBase); e != nil {
+							jobErr(e)
+						}
 					}
+					postsubmits = append(postsubmits, postsubmit)
+					postsubmitParents = append(postsubmitParents, parentJob.Name)
 				}
-				decorator.ApplyModifiersPostsubmit(&postsubmit, job.Modifiers)
-				decorator.ApplyRequirements(&postsubmit.JobBase, job.Requirements, job.ExcludedRequirements, jobsConfig.RequirementPresets)
-				postsubmits = append(postsubmits, postsubmit)
 			}
 
 			if sets.NewString(job.Types...).Has(TypePeriodic) {
-				name := fmt.Sprintf("%s_%s", job.Name, jobsConfig.Repo)
-				if branch != "master" {
-					name += "_" + branch
-				}
-				name += "_periodic"
-
-				// For periodic jobs, the repo needs to be added to the clonerefs and its root directory
-				// should be set as the working directory, so add itself to the repo list here.
-				job.Repos = append([]string{jobsConfig.Org + "/" + jobsConfig.Repo}, job.Repos...)
-
-				base, err := cli.createJobBase(baseConfig, jobsConfig, job, name, branch, jobsConfig.ResourcePresets)
-				if err != nil {
-					return output, err
-				}
-				periodic := config.Periodic{
-					JobBase:  base,
-					Interval: job.Interval,
-					Cron:     job.Cron,
-					Tags:     job.Tags,
-				}
-				if testgridConfig.Enabled {
-					if err := mergo.Merge(&periodic.JobBase.Annotations, map[string]string{
-						TestGridDashboard:   testgridJobPrefix + "_periodic",
-						TestGridAlertEmail:  testgridConfig.AlertEmail,
-						TestGridNumFailures: testgridConfig.NumFailuresToAlert,
-					}); err != nil {
-						return output, err
+				if periodic, e := cli.createPeriodic(jobsConfig, job, branch); e != nil {
+					jobErr(e)
+				} else {
+					if cli.EnforcePolicies {
+						if e := cli.checkPolicies(jobsConfig, job, TypePeriodic, branch, periodic.JobBase); e != nil {
+							jobErr(e)
+						}
 					}
+					periodics = append(periodics, periodic)
+					periodicParents = append(periodicParents, parentJob.Name)
 				}
-				decorator.ApplyRequirements(&periodic.JobBase, job.Requirements, job.ExcludedRequirements, jobsConfig.RequirementPresets)
-				periodics = append(periodics, periodic)
 			}
 		}
 
@@ -368,16 +656,237 @@ func (cli *Client) ConvertJobConfig(fileName string, jobsConfig spec.JobsConfig,
 			output.Periodics = periodics
 		}
 	}
-	return output, nil
+
+	// The Prow jobs are updated in place, so the output shares the changes.
+	bases := make([]*config.JobBase, 0, len(presubmits))
+	for i := range presubmits {
+		bases = append(bases, &presubmits[i].JobBase)
+	}
+	annotateNeeds(jobsConfig.Jobs, bases, presubmitParents)
+	bases = make([]*config.JobBase, 0, len(postsubmits))
+	for i := range postsubmits {
+		bases = append(bases, &postsubmits[i].JobBase)
+	}
+	annotateNeeds(jobsConfig.Jobs, bases, postsubmitParents)
+	bases = make([]*config.JobBase, 0, len(periodics))
+	for i := range periodics {
+		bases = append(bases, &periodics[i].JobBase)
+	}
+	annotateNeeds(jobsConfig.Jobs, bases, periodicParents)
+	return output, err
+}
+
+// changeMatcher returns the matcher of the changed files of the job, from the
+// regex or the path sets it references.
+func changeMatcher(job spec.Job) config.RegexpChangeMatcher {
+	switch {
+	case job.Regex != "":
+		return config.RegexpChangeMatcher{RunIfChanged: job.Regex}
+	case len(job.RunIfChanged) != 0:
+		return config.RegexpChangeMatcher{RunIfChanged: pathsRegex(job.Paths, job.RunIfChanged)}
+	case len(job.SkipIfOnlyChanged) != 0:
+		return config.RegexpChangeMatcher{SkipIfOnlyChanged: pathsRegex(job.Paths, job.SkipIfOnlyChanged)}
+	}
+	return config.RegexpChangeMatcher{}
+}
+
+// pathsRegex returns the regex matching any of the paths in the path sets.
+func pathsRegex(paths map[string][]string, names []string) string {
+	regexes := make([]string, 0)
+	seen := sets.NewString()
+	for _, name := range names {
+		for _, p := range paths[name] {
+			if !seen.Has(p) {
+				seen.Insert(p)
+				regexes = append(regexes, p)
+			}
+		}
+	}
+	return strings.Join(regexes, "|")
+}
+
+// testgridJobPrefix returns the prefix of the TestGrid dashboards for the jobs
+// of the repo on the branch.
+func testgridJobPrefix(jobsConfig spec.JobsConfig, branch string) string {
+	prefix := jobsConfig.Org
+	if branch != "master" {
+		prefix += "_" + branch
+	}
+	return prefix + "_" + jobsConfig.Repo
+}
+
+func brancher(branch string) config.Brancher {
+	return config.Brancher{
+		Branches: []string{fmt.Sprintf("^%s$", branch)},
+	}
+}
+
+func (cli *Client) createPresubmit(jobsConfig spec.JobsConfig, job spec.Job, branch string) (config.Presubmit, error) {
+	baseConfig := cli.BaseConfig
+	testgridConfig := baseConfig.TestgridConfig
+
+	name := fmt.Sprintf("%s_%s", job.Name, jobsConfig.Repo)
+	if branch != "master" {
+		name += "_" + branch
+	}
+
+	base, err := cli.createJobBase(baseConfig, jobsConfig, job, name, branch, jobsConfig.ResourcePresets)
+	if err != nil {
+		return config.Presubmit{}, err
+	}
+
+	presubmit := config.Presubmit{
+		JobBase:   base,
+		AlwaysRun: true,
+		Brancher:  brancher(branch),
+	}
+	if jobsConfig.HostType == HostTypeGerrit {
+		presubmit.Labels[client.GerritReportLabel] = gerritReportLabel(job.GerritPresubmitLabel)
+		// Gerrit supports the same /test and /retest commands as
+		// GitHub, so make the default ones explicit.
+		presubmit.Trigger = config.DefaultTriggerFor(presubmit.JobBase.Name)
+		presubmit.RerunCommand = config.DefaultRerunCommandFor(presubmit.JobBase.Name)
+	} else if job.GerritPresubmitLabel != "" {
+		presubmit.Labels[client.GerritReportLabel] = job.GerritPresubmitLabel
+	}
+	if uri := cloneURI(jobsConfig); uri != "" {
+		presubmit.UtilityConfig.CloneURI = uri
+	}
+	if pa, ok := baseConfig.PathAliases[jobsConfig.Org]; ok {
+		presubmit.UtilityConfig.PathAlias = fmt.Sprintf("%s/%s", pa, jobsConfig.Repo)
+	}
+	if matcher := changeMatcher(job); matcher.RunIfChanged != "" || matcher.SkipIfOnlyChanged != "" {
+		presubmit.RegexpChangeMatcher = matcher
+		presubmit.AlwaysRun = false
+	}
+	if job.Trigger != "" {
+		defaultTrigger := config.DefaultTriggerFor(presubmit.JobBase.Name)
+		// Match the default trigger + the new trigger.
+		presubmit.Trigger = fmt.Sprintf("(%s)|((?m)^%s(\\s+|$))", defaultTrigger, job.Trigger)
+	}
+	if testgridConfig.Enabled {
+		if err := mergo.Merge(&presubmit.JobBase.Annotations, map[string]string{
+			TestGridDashboard: testgridJobPrefix(jobsConfig, branch),
+		}); err != nil {
+			return config.Presubmit{}, err
+		}
+	}
+	var errs error
+	if err := decorator.ApplyModifiersPresubmit(&presubmit, job.Modifiers); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := decorator.ApplyRequirements(&presubmit.JobBase, job.Requirements, job.ExcludedRequirements, jobsConfig.RequirementPresets); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	return presubmit, errs
+}
+
+func (cli *Client) createPostsubmit(jobsConfig spec.JobsConfig, job spec.Job, branch string) (config.Postsubmit, error) {
+	baseConfig := cli.BaseConfig
+	testgridConfig := baseConfig.TestgridConfig
+
+	name := fmt.Sprintf("%s_%s", job.Name, jobsConfig.Repo)
+	if branch != "master" {
+		name += "_" + branch
+	}
+	name += "_postsubmit"
+
+	base, err := cli.createJobBase(baseConfig, jobsConfig, job, name, branch, jobsConfig.ResourcePresets)
+	if err != nil {
+		return config.Postsubmit{}, err
+	}
+
+	postsubmit := config.Postsubmit{
+		JobBase:  base,
+		Brancher: brancher(branch),
+	}
+	if jobsConfig.HostType == HostTypeGerrit {
+		postsubmit.Labels[client.GerritReportLabel] = gerritReportLabel(job.GerritPostsubmitLabel)
+	} else if job.GerritPostsubmitLabel != "" {
+		postsubmit.Labels[client.GerritReportLabel] = job.GerritPostsubmitLabel
+	}
+	if uri := cloneURI(jobsConfig); uri != "" {
+		postsubmit.UtilityConfig.CloneURI = uri
+	}
+	if pa, ok := baseConfig.PathAliases[jobsConfig.Org]; ok {
+		postsubmit.UtilityConfig.PathAlias = fmt.Sprintf("%s/%s", pa, jobsConfig.Repo)
+	}
+	if matcher := changeMatcher(job); matcher.RunIfChanged != "" || matcher.SkipIfOnlyChanged != "" {
+		postsubmit.RegexpChangeMatcher = matcher
+	}
+	if testgridConfig.Enabled {
+		if err := mergo.Merge(&postsubmit.JobBase.Annotations, map[string]string{
+			TestGridDashboard:   testgridJobPrefix(jobsConfig, branch) + "_postsubmit",
+			TestGridAlertEmail:  testgridConfig.AlertEmail,
+			TestGridNumFailures: testgridConfig.NumFailuresToAlert,
+		}); err != nil {
+			return config.Postsubmit{}, err
+		}
+	}
+	var errs error
+	if err := decorator.ApplyModifiersPostsubmit(&postsubmit, job.Modifiers); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := decorator.ApplyRequirements(&postsubmit.JobBase, job.Requirements, job.ExcludedRequirements, jobsConfig.RequirementPresets); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	return postsubmit, errs
+}
+
+func (cli *Client) createPeriodic(jobsConfig spec.JobsConfig, job spec.Job, branch string) (config.Periodic, error) {
+	baseConfig := cli.BaseConfig
+	testgridConfig := baseConfig.TestgridConfig
+
+	name := fmt.Sprintf("%s_%s", job.Name, jobsConfig.Repo)
+	if branch != "master" {
+		name += "_" + branch
+	}
+	name += "_periodic"
+
+	// For periodic jobs, the repo needs to be added to the clonerefs and its root directory
+	// should be set as the working directory, so add itself to the repo list here.
+	job.Repos = append([]string{jobsConfig.Org + "/" + jobsConfig.Repo}, job.Repos...)
+
+	base, err := cli.createJobBase(baseConfig, jobsConfig, job, name, branch, jobsConfig.ResourcePresets)
+	if err != nil {
+		return config.Periodic{}, err
+	}
+	periodic := config.Periodic{
+		JobBase:  base,
+		Interval: job.Interval,
+		Cron:     job.Cron,
+		Tags:     job.Tags,
+	}
+	if job.Cron == CronAuto {
+		if periodic.Cron, err = autoCron(name, job.ScheduleWindow); err != nil {
+			return config.Periodic{}, err
+		}
+	}
+	if testgridConfig.Enabled {
+		if err := mergo.Merge(&periodic.JobBase.Annotations, map[string]string{
+			TestGridDashboard:   testgridJobPrefix(jobsConfig, branch) + "_periodic",
+			TestGridAlertEmail:  testgridConfig.AlertEmail,
+			TestGridNumFailures: testgridConfig.NumFailuresToAlert,
+		}); err != nil {
+			return config.Periodic{}, err
+		}
+	}
+	var errs error
+	if err := decorator.ApplyModifiersPeriodic(&periodic, job.Modifiers); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := decorator.ApplyRequirements(&periodic.JobBase, job.Requirements, job.ExcludedRequirements, jobsConfig.RequirementPresets); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	return periodic, errs
 }
 
 func createContainer(jobConfig spec.JobsConfig, job spec.Job, resources map[string]v1.ResourceRequirements) []v1.Container {
 	envs := joinEnv(jobConfig.Env, job.Env)
 
-	yes := true
 	c := v1.Container{
 		Image:           job.Image,
-		SecurityContext: &v1.SecurityContext{Privileged: &yes},
+		SecurityContext: securityContext(job.SecurityContext),
 		Command:         job.Command,
 		Args:            job.Args,
 		Env:             envs,
@@ -388,7 +897,41 @@ func createContainer(jobConfig spec.JobsConfig, job spec.Job, resources map[stri
 
 	decorator.ApplyResource(&c, job.Resources, resources)
 
-	return []v1.Container{c}
+	containers := []v1.Container{c}
+	if len(job.Sidecars) != 0 {
+		// Containers must be named when there are multiple of them.
+		containers[0].Name = decorator.MainContainerName
+	}
+	for _, sidecar := range job.Sidecars {
+		sc := v1.Container{
+			Name:            sidecar.Name,
+			Image:           sidecar.Image,
+			SecurityContext: securityContext(sidecar.SecurityContext),
+			Command:         sidecar.Command,
+			Args:            sidecar.Args,
+			Env:             joinEnv(sidecar.Env),
+			VolumeMounts:    sidecar.VolumeMounts,
+		}
+		if sidecar.ImagePullPolicy != "" {
+			sc.ImagePullPolicy = v1.PullPolicy(sidecar.ImagePullPolicy)
+		}
+		if sidecar.Resources != "" {
+			decorator.ApplyResource(&sc, sidecar.Resources, resources)
+		}
+		containers = append(containers, sc)
+	}
+
+	return containers
+}
+
+// securityContext returns the given security context, or a privileged one if
+// it's not set.
+func securityContext(sc *v1.SecurityContext) *v1.SecurityContext {
+	if sc != nil {
+		return sc
+	}
+	yes := true
+	return &v1.SecurityContext{Privileged: &yes}
 }
 
 // joinEnv joins a set of environment variables, in order of lowest to highest priority
@@ -435,7 +978,7 @@ func (cli *Client) createJobBase(baseConfig spec.BaseConfig, jobConfig spec.Jobs
 		},
 		UtilityConfig: config.UtilityConfig{
 			Decorate:  &yes,
-			ExtraRefs: createExtraRefs(job.Repos, branch, baseConfig.PathAliases),
+			ExtraRefs: createExtraRefs(job.Repos, branch, baseConfig.PathAliases, jobConfig),
 		},
 		ReporterConfig: job.ReporterConfig,
 		Labels:         job.Labels,
@@ -459,6 +1002,14 @@ func (cli *Client) createJobBase(baseConfig spec.BaseConfig, jobConfig spec.Jobs
 	if job.ServiceAccountName != "" {
 		jb.Spec.ServiceAccountName = job.ServiceAccountName
 	}
+	jb.Spec.Tolerations = job.Tolerations
+	jb.Spec.Affinity = job.Affinity
+	jb.Spec.HostAliases = job.HostAliases
+	jb.Spec.DNSConfig = job.DNSConfig
+	jb.Spec.PriorityClassName = job.PriorityClassName
+	if job.RuntimeClassName != "" {
+		jb.Spec.RuntimeClassName = &job.RuntimeClassName
+	}
 
 	if job.TerminationGracePeriodSeconds != 0 {
 		jb.Spec.TerminationGracePeriodSeconds = &job.TerminationGracePeriodSeconds
@@ -483,7 +1034,28 @@ func (cli *Client) createJobBase(baseConfig spec.BaseConfig, jobConfig spec.Jobs
 	return jb, nil
 }
 
-func createExtraRefs(extraRepos []string, defaultBranch string, pathAliases map[string]string) []prowjob.Refs {
+// cloneURI returns the clone URI of the repo the jobs are configured for, or an
+// empty string if the default GitHub clone URI should be used.
+func cloneURI(jobsConfig spec.JobsConfig) string {
+	if jobsConfig.CloneURI != "" {
+		return jobsConfig.CloneURI
+	}
+	if jobsConfig.HostType == HostTypeGerrit {
+		return fmt.Sprintf("https://%s/%s", jobsConfig.Org, jobsConfig.Repo)
+	}
+	return ""
+}
+
+// gerritReportLabel returns the Gerrit label Prow will vote on, which falls
+// back to the Code-Review label if unset.
+func gerritReportLabel(label string) string {
+	if label == "" {
+		return client.CodeReview
+	}
+	return label
+}
+
+func createExtraRefs(extraRepos []string, defaultBranch string, pathAliases map[string]string, jobsConfig spec.JobsConfig) []prowjob.Refs {
 	refs := make([]prowjob.Refs, 0)
 	for _, extraRepo := range extraRepos {
 		branch := defaultBranch
@@ -503,10 +1075,13 @@ func createExtraRefs(extraRepos []string, defaultBranch string, pathAliases map[
 		if pa, ok := pathAliases[org]; ok {
 			ref.PathAlias = fmt.Sprintf("%s/%s", pa, repo)
 		}
-		// If the org name contains '.', it's assumed to be a Gerrit org, since
-		// '.' is not allowed in GitHub org names.
+		// The repo of the jobs config uses the clone URI of its host type.
+		// Otherwise if the org name contains '.', it's assumed to be a Gerrit
+		// org, since '.' is not allowed in GitHub org names.
 		// For Gerrit repos, the clone_uri should be always set as https://org/repo
-		if strings.Contains(org, ".") {
+		if uri := cloneURI(jobsConfig); uri != "" && orgrepo == jobsConfig.Org+"/"+jobsConfig.Repo {
+			ref.CloneURI = uri
+		} else if strings.Contains(org, ".") {
 			ref.CloneURI = "https://" + orgrepo
 		}
 		refs = append(refs, ref)
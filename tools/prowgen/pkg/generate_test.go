@@ -20,16 +20,23 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"k8s.io/test-infra/prow/config"
 
 	"istio.io/test-infra/tools/prowgen/pkg/spec"
 )
 
 func TestGenerateConfig(t *testing.T) {
-	bc := ReadBase(nil, "testdata/.base.yaml")
+	bc, err := ReadBase(nil, "testdata/.base.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read the base config: %v", err)
+	}
 	cli := &Client{BaseConfig: bc}
 	tests := []struct {
 		name        string
 		expectError bool
+		// expectedErrors are the validation errors expected for each branch,
+		// as "<job>: <message>", or only the message if there is no job.
+		expectedErrors []string
 	}{
 		{
 			name: "simple",
@@ -37,9 +44,127 @@ func TestGenerateConfig(t *testing.T) {
 		{
 			name: "matrix",
 		},
+		{
+			name: "matrix-rules",
+		},
+		{
+			name:        "matrix-collision",
+			expectError: true,
+		},
 		{
 			name: "params",
 		},
+		{
+			name: "expressions",
+		},
+		{
+			name:        "expressions-invalid",
+			expectError: true,
+			expectedErrors: []string{
+				`build: $(lower "a" unknown): unknown variable "unknown"`,
+				`build: $(replace branch "release-"): replace takes 3 arguments, got 2`,
+				`build: $(upper params.missing): param "missing" not configured in the params map map[arch:amd64]`,
+			},
+		},
+		{
+			name: "extends",
+		},
+		{
+			name: "gerrit",
+		},
+		{
+			name:        "gerrit-trigger",
+			expectError: true,
+		},
+		{
+			name: "sidecars",
+		},
+		{
+			name: "security-context",
+		},
+		{
+			name: "pod-spec",
+		},
+		{
+			name: "modifiers",
+		},
+		{
+			name:        "modifiers-invalid",
+			expectError: true,
+			expectedErrors: []string{
+				`merge: modifier "run_before_merge" is not supported: the version of Prow the jobs are generated for does not support run_before_merge`,
+				`nightly: modifier "cancel_previous" is not supported: Prow always aborts the running presubmits on a new commit, ` +
+					"and cannot do it for the other types of jobs",
+				`unknown: modifier "nonexistent" is not supported`,
+				`periodic: modifier "presubmit_optional" has no effect on [periodic] jobs`,
+			},
+		},
+		{
+			name: "architectures",
+		},
+		{
+			name:        "architectures-invalid",
+			expectError: true,
+			expectedErrors: []string{
+				`unit: architecture "riscv64" has nonexistent resource 'nonexistent'`,
+				"unit: 's390x' is not a valid architectures. Must be one of amd64, arm64, ppc64le, riscv64",
+				"unit: 'periodic' is not a valid architectures. Must be one of amd64, arm64, ppc64le, riscv64",
+			},
+		},
+		{
+			name: "paths",
+		},
+		{
+			name:        "paths-invalid",
+			expectError: true,
+			expectedErrors: []string{
+				"unit: run_if_changed and skip_if_only_changed cannot be both set",
+				"lint: regex cannot be set together with run_if_changed or skip_if_only_changed",
+				`lint: nonexistent path set "code"`,
+				"broken: invalid regex \"^(docs/\" in path set \"broken\": error parsing regexp: missing closing ): `^(docs/`",
+				`broken: path set "empty" is empty`,
+				`skipped: modifier "skip_if_only_changed" cannot be used with skip_if_only_changed`,
+			},
+		},
+		{
+			name: "needs",
+		},
+		{
+			name:        "needs-invalid",
+			expectError: true,
+			expectedErrors: []string{
+				"build: job cannot need itself",
+				`integ: needs nonexistent job "lint"`,
+				"nightly: needs is not supported for periodic jobs, they are triggered by their schedule",
+				`nightly: needs job "build", which is not generated for [periodic] jobs`,
+				"unit: needs cannot be set together with regex, run_if_changed or skip_if_only_changed, " +
+					"the job is only triggered after the jobs it needs",
+			},
+		},
+		{
+			name:           "needs-cycle",
+			expectError:    true,
+			expectedErrors: []string{"a: needs cycle: a -> c -> b -> a"},
+		},
+		{
+			name: "schedule",
+		},
+		{
+			name:        "schedule-invalid",
+			expectError: true,
+		},
+		{
+			name: "branch-overrides",
+		},
+		{
+			name:        "branch-overrides-invalid",
+			expectError: true,
+			expectedErrors: []string{
+				"branch_overrides for release-1.13, which is not in branches",
+				`branch_overrides for release-1.14 patches nonexistent job "nonexistent"`,
+				`branch_overrides for release-1.14 excludes nonexistent job "lint"`,
+			},
+		},
 		{
 			name:        "long-job-name",
 			expectError: true,
@@ -48,30 +173,137 @@ func TestGenerateConfig(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			file := fmt.Sprintf("testdata/%s.yaml", tt.name)
-			jobs := cli.ReadJobsConfig(file)
+			jobs, err := cli.ReadJobsConfig(file)
+			if err != nil {
+				t.Fatalf("Test %q failed to read the jobs config: %v", tt.name, err)
+			}
+			// The jobs of all the branches are checked against the same file.
+			var combined config.JobConfig
 			for _, branch := range jobs.Branches {
 				output, err := cli.ConvertJobConfig(file, jobs, branch)
 				if tt.expectError {
 					if err == nil {
 						t.Fatalf("Test %q expected an error, but did not receive one", tt.name)
 					}
+					if tt.expectedErrors != nil {
+						errs := make([]string, 0)
+						for _, e := range ValidationErrors(err) {
+							if e.Job != "" {
+								errs = append(errs, e.Job+": "+e.Message)
+							} else {
+								errs = append(errs, e.Message)
+							}
+						}
+						if diff := cmp.Diff(tt.expectedErrors, errs); diff != "" {
+							t.Fatalf("Test %q errors do not match for branch %s, (-want, +got): \n%s", tt.name, branch, diff)
+						}
+					}
 					// there should be no generated file when an error occurs
 					continue
 				} else if err != nil {
 					t.Fatalf("Test %q did not expect an error, but received %v", tt.name, err)
 				}
-				testFile := fmt.Sprintf("testdata/%s.gen.yaml", tt.name)
-				if os.Getenv("REFRESH_GOLDEN") == "true" {
-					Write(output, testFile, bc.AutogenHeader)
-				}
-				if err := Check(output, testFile, bc.AutogenHeader); err != nil {
-					t.Fatal(err.Error())
-				}
+				combined = CombineJobConfigs(combined, output)
+			}
+			if tt.expectError {
+				return
+			}
+			testFile := fmt.Sprintf("testdata/%s.gen.yaml", tt.name)
+			if os.Getenv("REFRESH_GOLDEN") == "true" {
+				Write(combined, testFile, bc.AutogenHeader)
+			}
+			if err := Check(combined, testFile, bc.AutogenHeader); err != nil {
+				t.Fatal(err.Error())
+			}
+		})
+	}
+}
+
+func TestResolveExtends(t *testing.T) {
+	tests := []struct {
+		name        string
+		jobs        []spec.Job
+		expectError bool
+	}{
+		{
+			name: "chain",
+			jobs: []spec.Job{
+				{Name: "a", Command: []string{"cmd"}},
+				{Name: "b", Extends: "a"},
+				{Name: "c", Extends: "b"},
+			},
+		},
+		{
+			name: "cycle",
+			jobs: []spec.Job{
+				{Name: "a", Extends: "c"},
+				{Name: "b", Extends: "a"},
+				{Name: "c", Extends: "b"},
+			},
+			expectError: true,
+		},
+		{
+			name:        "self",
+			jobs:        []spec.Job{{Name: "a", Extends: "a"}},
+			expectError: true,
+		},
+		{
+			name:        "nonexistent",
+			jobs:        []spec.Job{{Name: "a", Extends: "b"}},
+			expectError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := resolveExtends("test.yaml", tt.jobs)
+			if tt.expectError && err == nil {
+				t.Fatalf("Test %q expected an error, but did not receive one", tt.name)
+			} else if !tt.expectError && err != nil {
+				t.Fatalf("Test %q did not expect an error, but received %v", tt.name, err)
 			}
 		})
 	}
 }
 
+func TestGenerateTestgridConfig(t *testing.T) {
+	bc, err := ReadBase(nil, "testdata/.base.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read the base config: %v", err)
+	}
+	cli := &Client{BaseConfig: bc}
+	file := "testdata/simple.yaml"
+	jobs, err := cli.ReadJobsConfig(file)
+	if err != nil {
+		t.Fatalf("Failed to read the jobs config: %v", err)
+	}
+	outputs := make([]config.JobConfig, 0)
+	for _, branch := range jobs.Branches {
+		output, err := cli.ConvertJobConfig(file, jobs, branch)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		outputs = append(outputs, output)
+	}
+
+	if _, err := GenerateTestgridConfig(outputs, ""); err == nil {
+		t.Fatal("Expected an error when no GCS bucket is configured, but did not receive one")
+	}
+	if _, err := GenerateTestgridConfig(append(outputs, outputs...), "gs://istio-prow"); err == nil {
+		t.Fatal("Expected an error for duplicate test group names, but did not receive one")
+	}
+	testgridConfig, err := GenerateTestgridConfig(outputs, "gs://istio-prow")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	testFile := "testdata/testgrid.gen.yaml"
+	if os.Getenv("REFRESH_GOLDEN") == "true" {
+		WriteTestgridConfig(testgridConfig, testFile, bc.AutogenHeader)
+	}
+	if err := CheckTestgridConfig(testgridConfig, testFile, bc.AutogenHeader); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
 func TestFilterReleaseBranchingJobs(t *testing.T) {
 	testCases := []struct {
 		name         string
@@ -130,6 +362,31 @@ func TestFilterReleaseBranchingJobs(t *testing.T) {
 			},
 			filteredJobs: []spec.Job{},
 		},
+		{
+			name: "drop the needs on disabled release branching jobs",
+			jobs: []spec.Job{
+				{
+					Name:                    "job_1",
+					DisableReleaseBranching: true,
+				},
+				{
+					Name: "job_2",
+				},
+				{
+					Name:  "job_3",
+					Needs: []string{"job_1", "job_2"},
+				},
+			},
+			filteredJobs: []spec.Job{
+				{
+					Name: "job_2",
+				},
+				{
+					Name:  "job_3",
+					Needs: []string{"job_2"},
+				},
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -141,3 +398,28 @@ func TestFilterReleaseBranchingJobs(t *testing.T) {
 		}
 	}
 }
+
+func TestValidationErrors(t *testing.T) {
+	bc, err := ReadBase(nil, "testdata/.base.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read the base config: %v", err)
+	}
+	cli := &Client{BaseConfig: bc}
+	file := "testdata/gerrit-trigger.yaml"
+	jobs, err := cli.ReadJobsConfig(file)
+	if err != nil {
+		t.Fatalf("Failed to read the jobs config: %v", err)
+	}
+	_, err = cli.ConvertJobConfig(file, jobs, jobs.Branches[0])
+
+	want := []*ValidationError{{
+		File:    file,
+		Job:     "unit",
+		Line:    7,
+		Column:  5,
+		Message: "trigger is only supported for GitHub repos",
+	}}
+	if diff := cmp.Diff(want, ValidationErrors(err)); diff != "" {
+		t.Fatalf("Validation errors do not match, (-want, +got): \n%s", diff)
+	}
+}
@@ -0,0 +1,921 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/test-infra/prow/config"
+	"k8s.io/test-infra/prow/gerrit/client"
+	"sigs.k8s.io/yaml"
+
+	"istio.io/test-infra/tools/prowgen/pkg/decorator"
+	"istio.io/test-infra/tools/prowgen/pkg/spec"
+)
+
+// archLabel is the node selector added to the jobs for their architecture.
+const archLabel = "kubernetes.io/arch"
+
+// ImportedFile is a meta config file recovered from the Prow jobs of a repo on
+// a branch.
+type ImportedFile struct {
+	// Path is the path of the file, relative to the input directory.
+	Path       string
+	JobsConfig spec.JobsConfig
+	// Diffs are the differences between the imported Prow jobs and the ones
+	// generated from the file, which have to be fixed by hand.
+	Diffs []JobDiff
+}
+
+// importGroup is the Prow jobs of a repo on a branch, which are imported into
+// one meta config file.
+type importGroup struct {
+	org    string
+	repo   string
+	branch string
+	jobs   config.JobConfig
+}
+
+// ReadProwJobConfig reads the Prow job config file to import. It's parsed
+// strictly, so that a file in another format, e.g. a meta config file, is not
+// read as an empty Prow job config.
+func ReadProwJobConfig(file string) (config.JobConfig, error) {
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return config.JobConfig{}, err
+	}
+	jobs := config.JobConfig{}
+	if err := yaml.UnmarshalStrict(bs, &jobs); err != nil {
+		return config.JobConfig{}, fmt.Errorf("failed to parse %s: %v", file, err)
+	}
+	return jobs, nil
+}
+
+// Import converts the Prow jobs into meta config files, one for each repo and
+// branch, relative to the base config of the client. The fields shared by all
+// the jobs are moved to the file, the requirement and resource presets of the
+// base config are recovered from the pod specs, and the resources and volumes
+// that do not match any preset are added as presets of the file. Each file is
+// then converted back to confirm it generates the same Prow jobs.
+func (cli *Client) Import(jobs config.JobConfig) ([]ImportedFile, error) {
+	if len(jobs.AllStaticPresubmits(nil)) == 0 && len(jobs.AllStaticPostsubmits(nil)) == 0 && len(jobs.AllPeriodics()) == 0 {
+		return nil, errors.New("there is no Prow job to import")
+	}
+	groups, err := groupImportedJobs(jobs)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]ImportedFile, 0, len(groups))
+	paths := sets.NewString()
+	for _, g := range groups {
+		path := g.repo + ".yaml"
+		if g.branch != "master" {
+			path = fmt.Sprintf("%s-%s.yaml", g.repo, strings.TrimPrefix(g.branch, "release-"))
+		}
+		if paths.Has(path) {
+			err = multierror.Append(err, fmt.Errorf("the jobs of %s/%s:%s and another repo would be imported to the same file %s",
+				g.org, g.repo, g.branch, path))
+			continue
+		}
+		paths.Insert(path)
+
+		jobsConfig, e := cli.importGroup(g)
+		if e != nil {
+			err = multierror.Append(err, e)
+			continue
+		}
+		diffs, e := cli.roundTrip(path, jobsConfig, g)
+		if e != nil {
+			err = multierror.Append(err, fmt.Errorf("%s: %v", path, e))
+			continue
+		}
+		res = append(res, ImportedFile{Path: path, JobsConfig: jobsConfig, Diffs: diffs})
+	}
+	return res, err
+}
+
+// groupImportedJobs groups the Prow jobs by repo and branch, in the order the
+// groups first appear. The repo of a periodic job is its first extra ref, as
+// the generated periodic jobs clone their repo first.
+func groupImportedJobs(jobs config.JobConfig) ([]*importGroup, error) {
+	var groups []*importGroup
+	index := map[string]*importGroup{}
+	group := func(org, repo, branch string) *importGroup {
+		key := fmt.Sprintf("%s/%s:%s", org, repo, branch)
+		if g, ok := index[key]; ok {
+			return g
+		}
+		g := &importGroup{org: org, repo: repo, branch: branch, jobs: config.JobConfig{
+			PresubmitsStatic:  map[string][]config.Presubmit{},
+			PostsubmitsStatic: map[string][]config.Postsubmit{},
+			Periodics:         []config.Periodic{},
+		}}
+		index[key] = g
+		groups = append(groups, g)
+		return g
+	}
+
+	var err error
+	for _, orgRepo := range sets.StringKeySet(jobs.PresubmitsStatic).List() {
+		org, repo, e := splitOrgRepo(orgRepo)
+		if e != nil {
+			err = multierror.Append(err, e)
+			continue
+		}
+		for _, p := range jobs.PresubmitsStatic[orgRepo] {
+			g := group(org, repo, importedBranch(p.Brancher))
+			g.jobs.PresubmitsStatic[orgRepo] = append(g.jobs.PresubmitsStatic[orgRepo], p)
+		}
+	}
+	for _, orgRepo := range sets.StringKeySet(jobs.PostsubmitsStatic).List() {
+		org, repo, e := splitOrgRepo(orgRepo)
+		if e != nil {
+			err = multierror.Append(err, e)
+			continue
+		}
+		for _, p := range jobs.PostsubmitsStatic[orgRepo] {
+			g := group(org, repo, importedBranch(p.Brancher))
+			g.jobs.PostsubmitsStatic[orgRepo] = append(g.jobs.PostsubmitsStatic[orgRepo], p)
+		}
+	}
+	for _, p := range jobs.Periodics {
+		if len(p.ExtraRefs) == 0 {
+			err = multierror.Append(err, fmt.Errorf("periodic job %q has no extra_refs to derive its repo from", p.Name))
+			continue
+		}
+		ref := p.ExtraRefs[0]
+		g := group(ref.Org, ref.Repo, ref.BaseRef)
+		g.jobs.Periodics = append(g.jobs.Periodics, p)
+	}
+	return groups, err
+}
+
+func splitOrgRepo(orgRepo string) (string, string, error) {
+	i := strings.LastIndex(orgRepo, "/")
+	if i < 0 {
+		return "", "", fmt.Errorf("repo %v not valid, should take form org/repo", orgRepo)
+	}
+	return orgRepo[:i], orgRepo[i+1:], nil
+}
+
+// importedBranch returns the branch a job runs on, which must be the only
+// branch it's configured for. The jobs for all the branches are imported for
+// master, and the round trip reports the difference.
+func importedBranch(b config.Brancher) string {
+	if len(b.Branches) == 0 {
+		return "master"
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(b.Branches[0], "^"), "$")
+}
+
+// importGroup converts the Prow jobs of a repo on a branch into a meta config.
+func (cli *Client) importGroup(g *importGroup) (spec.JobsConfig, error) {
+	jobsConfig := spec.JobsConfig{
+		Org:  g.org,
+		Repo: g.repo,
+	}
+	if g.branch != "master" {
+		jobsConfig.Branches = []string{g.branch}
+	}
+
+	orgRepo := g.org + "/" + g.repo
+	// The presubmit and postsubmit jobs of a Gerrit repo clone it from its
+	// host, and report to the Gerrit labels.
+	gerrit := false
+	for _, p := range g.jobs.PresubmitsStatic[orgRepo] {
+		gerrit = gerrit || p.CloneURI == fmt.Sprintf("https://%s/%s", g.org, g.repo)
+	}
+	for _, p := range g.jobs.PostsubmitsStatic[orgRepo] {
+		gerrit = gerrit || p.CloneURI == fmt.Sprintf("https://%s/%s", g.org, g.repo)
+	}
+	if gerrit {
+		jobsConfig.HostType = HostTypeGerrit
+	}
+	gerritLabel := func(job *spec.Job) string {
+		label := job.Labels[client.GerritReportLabel]
+		if !gerrit {
+			return ""
+		}
+		delete(job.Labels, client.GerritReportLabel)
+		if len(job.Labels) == 0 {
+			job.Labels = nil
+		}
+		if label == client.CodeReview {
+			return ""
+		}
+		return label
+	}
+
+	var err error
+	var presubmits, postsubmits, periodics []spec.Job
+	// The names of the jobs the Prow jobs are imported to, for their needs.
+	names := map[string]string{}
+	for _, p := range g.jobs.PresubmitsStatic[orgRepo] {
+		job, e := cli.importJobBase(&jobsConfig, p.JobBase, importedJobName(p.Name, g, ""), g.branch)
+		if e != nil {
+			err = multierror.Append(err, e)
+			continue
+		}
+		job.Types = []string{TypePresubmit}
+		names[p.Name] = job.Name
+		job.GerritPresubmitLabel = gerritLabel(&job)
+		if p.CloneURI != "" && !gerrit {
+			jobsConfig.CloneURI = p.CloneURI
+		}
+		if p.Optional {
+			job.Modifiers = append(job.Modifiers, decorator.ModifierPresubmitOptional)
+		}
+		switch {
+		case p.RunIfChanged != "":
+			job.Regex = p.RunIfChanged
+		case p.SkipIfOnlyChanged != "":
+			job.Regex = p.SkipIfOnlyChanged
+			job.Modifiers = append(job.Modifiers, decorator.ModifierSkipIfOnlyChanged)
+		case p.Annotations[NeedsAnnotation] != "":
+			// The presubmits with needs are never triggered by Prow.
+		case !p.AlwaysRun:
+			job.Modifiers = append(job.Modifiers, decorator.ModifierPresubmitSkipped)
+		}
+		if p.SkipReport {
+			job.Modifiers = append(job.Modifiers, decorator.ModifierHidden)
+			job.ReporterConfig = nil
+		}
+		prefix := "(" + config.DefaultTriggerFor(p.Name) + ")|((?m)^"
+		suffix := "(\\s+|$))"
+		if strings.HasPrefix(p.Trigger, prefix) && strings.HasSuffix(p.Trigger, suffix) {
+			job.Trigger = strings.TrimSuffix(strings.TrimPrefix(p.Trigger, prefix), suffix)
+		}
+		presubmits = append(presubmits, job)
+	}
+	for _, p := range g.jobs.PostsubmitsStatic[orgRepo] {
+		job, e := cli.importJobBase(&jobsConfig, p.JobBase, importedJobName(p.Name, g, "_postsubmit"), g.branch)
+		if e != nil {
+			err = multierror.Append(err, e)
+			continue
+		}
+		job.Types = []string{TypePostsubmit}
+		names[p.Name] = job.Name
+		job.GerritPostsubmitLabel = gerritLabel(&job)
+		if p.CloneURI != "" && !gerrit {
+			jobsConfig.CloneURI = p.CloneURI
+		}
+		switch {
+		case p.RunIfChanged != "":
+			job.Regex = p.RunIfChanged
+		case p.SkipIfOnlyChanged != "":
+			job.Regex = p.SkipIfOnlyChanged
+			job.Modifiers = append(job.Modifiers, decorator.ModifierSkipIfOnlyChanged)
+		}
+		if p.SkipReport {
+			job.Modifiers = append(job.Modifiers, decorator.ModifierHidden)
+			job.ReporterConfig = nil
+		}
+		postsubmits = append(postsubmits, job)
+	}
+	for _, p := range g.jobs.Periodics {
+		// The repo itself is added back as the first extra ref.
+		p.ExtraRefs = p.ExtraRefs[1:]
+		job, e := cli.importJobBase(&jobsConfig, p.JobBase, importedJobName(p.Name, g, "_periodic"), g.branch)
+		if e != nil {
+			err = multierror.Append(err, e)
+			continue
+		}
+		job.Types = []string{TypePeriodic}
+		job.Interval = p.Interval
+		job.Cron = p.Cron
+		job.Tags = p.Tags
+		periodics = append(periodics, job)
+	}
+	if err != nil {
+		return spec.JobsConfig{}, err
+	}
+	for _, jobs := range [][]spec.Job{presubmits, postsubmits} {
+		for i := range jobs {
+			importNeeds(&jobs[i], names)
+		}
+	}
+
+	jobs := cli.mergeImportedArchitectures(presubmits)
+	for _, job := range cli.mergeImportedArchitectures(postsubmits) {
+		jobs = mergeImportedPostsubmit(jobs, job)
+	}
+	jobs = append(jobs, cli.mergeImportedArchitectures(periodics)...)
+	for i := range jobs {
+		if sets.NewString(jobs[i].Types...).Equal(sets.NewString(TypePresubmit, TypePostsubmit)) {
+			jobs[i].Types = nil
+		}
+		if reflect.DeepEqual(jobs[i].Architectures, []string{ArchAMD64}) {
+			jobs[i].Architectures = nil
+		}
+	}
+	jobsConfig.Jobs = jobs
+	hoistCommonFields(&jobsConfig)
+	return jobsConfig, nil
+}
+
+// importNeeds recovers the needs of the job from the annotations of its Prow
+// job, which are generated again from the needs.
+func importNeeds(job *spec.Job, names map[string]string) {
+	if v := job.Annotations[NeedsAnnotation]; v != "" {
+		needs := sets.NewString()
+		for _, n := range strings.Split(v, ",") {
+			if name := names[n]; !needs.Has(name) {
+				needs.Insert(name)
+				job.Needs = append(job.Needs, name)
+			}
+		}
+	}
+	delete(job.Annotations, NeedsAnnotation)
+	delete(job.Annotations, NeededByAnnotation)
+	if len(job.Annotations) == 0 {
+		job.Annotations = nil
+	}
+}
+
+// importedJobName recovers the name of the job from the name of a Prow job
+// generated from it, i.e. <name>_<repo>[_<branch>][_<type>].
+func importedJobName(name string, g *importGroup, suffix string) string {
+	name = strings.TrimSuffix(name, suffix)
+	if g.branch != "master" {
+		name = strings.TrimSuffix(name, "_"+g.branch)
+	}
+	return strings.TrimSuffix(name, "_"+g.repo)
+}
+
+// mergeImportedArchitectures merges the jobs of the same type generated from a
+// job for different architectures. The image of the jobs for an architecture
+// configured with an image is ignored, since it's replaced anyway.
+func (cli *Client) mergeImportedArchitectures(jobs []spec.Job) []spec.Job {
+	archImage := func(job spec.Job) bool {
+		ac := cli.BaseConfig.ArchitectureConfigs[job.Architectures[0]]
+		return ac.Image != "" && ac.Image == job.Image
+	}
+	var res []spec.Job
+	// onlyArchImages tracks the merged jobs that only have the images of the
+	// architecture configs.
+	var onlyArchImages []bool
+	for _, job := range jobs {
+		merged := false
+		for i := range res {
+			a, b := res[i].DeepCopy(), job.DeepCopy()
+			a.Architectures, b.Architectures = nil, nil
+			if onlyArchImages[i] {
+				a.Image = b.Image
+			} else if archImage(job) {
+				b.Image = a.Image
+			}
+			if equality.Semantic.DeepEqual(a, b) {
+				res[i].Architectures = append(res[i].Architectures, job.Architectures...)
+				if onlyArchImages[i] && !archImage(job) {
+					res[i].Image = job.Image
+					onlyArchImages[i] = false
+				}
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			res = append(res, job)
+			onlyArchImages = append(onlyArchImages, archImage(job))
+		}
+	}
+	return res
+}
+
+// presubmitModifiers are the modifiers that only change presubmit jobs, so a
+// postsubmit job can be merged into a presubmit job that has them.
+var presubmitModifiers = sets.NewString(
+	decorator.ModifierPresubmitOptional,
+	decorator.ModifierPresubmitSkipped,
+)
+
+// mergeImportedPostsubmit adds the postsubmit job to the presubmit job with the
+// same name and config, or appends it as a separate job.
+func mergeImportedPostsubmit(jobs []spec.Job, postsubmit spec.Job) []spec.Job {
+	comparable := func(job spec.Job) spec.Job {
+		job = job.DeepCopy()
+		job.Types = nil
+		job.Trigger = ""
+		modifiers := make([]string, 0)
+		for _, m := range job.Modifiers {
+			if !presubmitModifiers.Has(m) {
+				modifiers = append(modifiers, m)
+			}
+		}
+		job.Modifiers = modifiers
+		return job
+	}
+	for i, job := range jobs {
+		if job.Name == postsubmit.Name && sets.NewString(job.Types...).Equal(sets.NewString(TypePresubmit)) &&
+			equality.Semantic.DeepEqual(comparable(job), comparable(postsubmit)) {
+			jobs[i].Types = append(jobs[i].Types, TypePostsubmit)
+			return jobs
+		}
+	}
+	return append(jobs, postsubmit)
+}
+
+// importJobBase converts the fields shared by all the types of Prow jobs. The
+// resource and requirement presets that are missing are added to the config.
+func (cli *Client) importJobBase(jobsConfig *spec.JobsConfig, base config.JobBase, name, branch string) (spec.Job, error) {
+	if base.Spec == nil || len(base.Spec.Containers) == 0 {
+		return spec.Job{}, fmt.Errorf("prow job %q has no containers, which cannot be imported", base.Name)
+	}
+	bc := cli.BaseConfig
+	podSpec := base.Spec.DeepCopy()
+	main := podSpec.Containers[0]
+	job := spec.Job{Name: name, Architectures: []string{ArchAMD64}}
+	// The jobs for the other architectures are suffixed with the architecture.
+	arch := ArchAMD64
+	if a, ok := podSpec.NodeSelector[archLabel]; ok {
+		arch = a
+		delete(podSpec.NodeSelector, archLabel)
+	}
+	if arch != ArchAMD64 {
+		job.Name = strings.TrimSuffix(name, "-"+arch)
+		job.Architectures = []string{arch}
+	}
+	// The architectures other than amd64 and arm64 must be configured before
+	// they can be used.
+	if _, ok := bc.ArchitectureConfigs[arch]; !ok && arch != ArchAMD64 && arch != ArchARM64 {
+		if jobsConfig.ArchitectureConfigs == nil {
+			jobsConfig.ArchitectureConfigs = map[string]spec.ArchitectureConfig{}
+		}
+		jobsConfig.ArchitectureConfigs[arch] = spec.ArchitectureConfig{}
+	}
+	// The cluster and the image are set for the architecture if it's
+	// configured.
+	archConfig := bc.ArchitectureConfigs[arch]
+	if c, ok := bc.ClusterOverrides[arch]; ok && archConfig.Cluster == "" {
+		archConfig.Cluster = c
+	}
+
+	labels := copyMap(base.Labels)
+	annotations := copyMap(base.Annotations)
+	if bc.TestgridConfig.Enabled {
+		for _, k := range []string{TestGridDashboard, TestGridAlertEmail, TestGridNumFailures} {
+			delete(annotations, k)
+		}
+	}
+
+	// The requirement presets are recovered from the labels, annotations, env,
+	// volumes, volume mounts and args they add to the main container.
+	env := main.Env
+	volumes := podSpec.Volumes
+	mounts := main.VolumeMounts
+	args := main.Args
+	var requirements []string
+	for _, name := range sortedKeys(bc.RequirementPresets) {
+		preset := bc.RequirementPresets[name]
+		if !requirementApplied(preset, labels, annotations, env, volumes, mounts, args) {
+			continue
+		}
+		requirements = append(requirements, name)
+	}
+	// The requirements add their volumes in order, so they are sorted by the
+	// first of them in the pod spec.
+	position := func(preset spec.RequirementPreset) int {
+		for i, m := range mounts {
+			for _, pm := range preset.VolumeMounts {
+				if equality.Semantic.DeepEqual(m, pm) {
+					return i
+				}
+			}
+		}
+		for i, v := range volumes {
+			for _, pv := range preset.Volumes {
+				if equality.Semantic.DeepEqual(v, pv) {
+					return len(mounts) + i
+				}
+			}
+		}
+		return len(mounts) + len(volumes)
+	}
+	sort.SliceStable(requirements, func(i, j int) bool {
+		return position(bc.RequirementPresets[requirements[i]]) < position(bc.RequirementPresets[requirements[j]])
+	})
+	for _, name := range requirements {
+		preset := bc.RequirementPresets[name]
+		for k := range preset.Labels {
+			delete(labels, k)
+		}
+		for k := range preset.Annotations {
+			delete(annotations, k)
+		}
+		env = removeEnv(env, preset.Env)
+		volumes = removeVolumes(volumes, preset.Volumes)
+		mounts = removeMounts(mounts, preset.VolumeMounts)
+		if hasSuffix(args, preset.Args) {
+			args = args[:len(args)-len(preset.Args)]
+		}
+	}
+	defaults := sets.NewString(bc.Requirements...).Difference(sets.NewString(bc.ExcludedRequirements...))
+	for _, r := range requirements {
+		if !defaults.Has(r) {
+			job.Requirements = append(job.Requirements, r)
+		}
+	}
+	for _, r := range defaults.List() {
+		if !sets.NewString(requirements...).Has(r) {
+			job.ExcludedRequirements = append(job.ExcludedRequirements, r)
+		}
+	}
+	// The volumes that do not belong to any preset are added with a preset of
+	// the file named after the job.
+	if len(volumes) != 0 || len(mounts) != 0 {
+		if jobsConfig.RequirementPresets == nil {
+			jobsConfig.RequirementPresets = map[string]spec.RequirementPreset{}
+		}
+		preset := spec.RequirementPreset{Volumes: volumes, VolumeMounts: mounts}
+		presetName := name
+		for _, n := range sortedKeys(jobsConfig.RequirementPresets) {
+			if equality.Semantic.DeepEqual(preset, jobsConfig.RequirementPresets[n]) {
+				presetName = n
+				break
+			}
+		}
+		jobsConfig.RequirementPresets[presetName] = preset
+		job.Requirements = append(job.Requirements, presetName)
+	}
+
+	for k, v := range bc.Labels {
+		if labels[k] == v {
+			delete(labels, k)
+		}
+	}
+	for k, v := range bc.Annotations {
+		if annotations[k] == v {
+			delete(annotations, k)
+		}
+	}
+	if len(labels) != 0 {
+		job.Labels = labels
+	}
+	if len(annotations) != 0 {
+		job.Annotations = annotations
+	}
+	job.Env = removeEnv(env, bc.Env)
+
+	job.Image = main.Image
+	job.ImagePullPolicy = string(main.ImagePullPolicy)
+	job.Command = main.Command
+	job.Args = args
+	job.SecurityContext = importedSecurityContext(main.SecurityContext, bc.SecurityContext)
+	job.Resources = importResources(jobsConfig, bc.ResourcePresets, main.Resources, name, true)
+	for _, c := range podSpec.Containers[1:] {
+		job.Sidecars = append(job.Sidecars, spec.Sidecar{
+			Name:            c.Name,
+			Image:           c.Image,
+			ImagePullPolicy: string(c.ImagePullPolicy),
+			Command:         c.Command,
+			Args:            c.Args,
+			Env:             c.Env,
+			Resources:       importResources(jobsConfig, bc.ResourcePresets, c.Resources, name+"-"+c.Name, false),
+			VolumeMounts:    c.VolumeMounts,
+			SecurityContext: importedSecurityContext(c.SecurityContext, nil),
+		})
+	}
+
+	if len(podSpec.NodeSelector) != 0 && !reflect.DeepEqual(podSpec.NodeSelector, bc.NodeSelector) {
+		job.NodeSelector = podSpec.NodeSelector
+	}
+	if base.Cluster != bc.Cluster && (archConfig.Cluster == "" || base.Cluster != archConfig.Cluster) {
+		job.Cluster = base.Cluster
+	}
+	job.ServiceAccountName = podSpec.ServiceAccountName
+	// The tolerations and the affinity are replaced for the architecture if
+	// they are configured, like the cluster.
+	if !equality.Semantic.DeepEqual(podSpec.Tolerations, bc.Tolerations) &&
+		(len(archConfig.Tolerations) == 0 || !equality.Semantic.DeepEqual(podSpec.Tolerations, archConfig.Tolerations)) {
+		job.Tolerations = podSpec.Tolerations
+	}
+	if !equality.Semantic.DeepEqual(podSpec.Affinity, bc.Affinity) &&
+		(archConfig.Affinity == nil || !equality.Semantic.DeepEqual(podSpec.Affinity, archConfig.Affinity)) {
+		job.Affinity = podSpec.Affinity
+	}
+	if !equality.Semantic.DeepEqual(podSpec.HostAliases, bc.HostAliases) {
+		job.HostAliases = podSpec.HostAliases
+	}
+	if !equality.Semantic.DeepEqual(podSpec.DNSConfig, bc.DNSConfig) {
+		job.DNSConfig = podSpec.DNSConfig
+	}
+	job.PriorityClassName = podSpec.PriorityClassName
+	if podSpec.RuntimeClassName != nil {
+		job.RuntimeClassName = *podSpec.RuntimeClassName
+	}
+	for _, s := range podSpec.ImagePullSecrets {
+		job.ImagePullSecrets = append(job.ImagePullSecrets, s.Name)
+	}
+	if podSpec.TerminationGracePeriodSeconds != nil {
+		job.TerminationGracePeriodSeconds = *podSpec.TerminationGracePeriodSeconds
+	}
+	job.MaxConcurrency = base.MaxConcurrency
+	job.ReporterConfig = base.ReporterConfig
+	if dc := base.DecorationConfig; dc != nil {
+		job.Timeout = dc.Timeout
+		if dc.GCSConfiguration != nil {
+			job.GCSLogBucket = dc.GCSConfiguration.Bucket
+		}
+	}
+	for _, ref := range base.ExtraRefs {
+		repo := ref.Org + "/" + ref.Repo
+		if ref.BaseRef != branch {
+			repo += "@" + ref.BaseRef
+		}
+		job.Repos = append(job.Repos, repo)
+	}
+	return job, nil
+}
+
+// requirementApplied returns whether everything the requirement preset adds to
+// the main container and the job is there. The presets that add to the other
+// containers are not recovered.
+func requirementApplied(preset spec.RequirementPreset, labels, annotations map[string]string,
+	env []v1.EnvVar, volumes []v1.Volume, mounts []v1.VolumeMount, args []string) bool {
+	if len(preset.Containers) != 0 && !reflect.DeepEqual(preset.Containers, []string{decorator.MainContainerName}) {
+		return false
+	}
+	if len(preset.Labels)+len(preset.Annotations)+len(preset.Env)+len(preset.Volumes)+
+		len(preset.VolumeMounts)+len(preset.Args) == 0 {
+		return false
+	}
+	for k, v := range preset.Labels {
+		if val, ok := labels[k]; !ok || val != v {
+			return false
+		}
+	}
+	for k, v := range preset.Annotations {
+		if val, ok := annotations[k]; !ok || val != v {
+			return false
+		}
+	}
+	if len(removeEnv(preset.Env, env)) != 0 || len(removeVolumes(preset.Volumes, volumes)) != 0 ||
+		len(removeMounts(preset.VolumeMounts, mounts)) != 0 {
+		return false
+	}
+	return hasSuffix(args, preset.Args)
+}
+
+// hasSuffix returns whether the list ends with the suffix.
+func hasSuffix(lst, suffix []string) bool {
+	if len(suffix) > len(lst) {
+		return false
+	}
+	for i, s := range suffix {
+		if lst[len(lst)-len(suffix)+i] != s {
+			return false
+		}
+	}
+	return true
+}
+
+func removeEnv(env, remove []v1.EnvVar) []v1.EnvVar {
+	var res []v1.EnvVar
+	for _, e := range env {
+		found := false
+		for _, r := range remove {
+			if equality.Semantic.DeepEqual(e, r) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+func removeVolumes(volumes, remove []v1.Volume) []v1.Volume {
+	var res []v1.Volume
+	for _, v := range volumes {
+		found := false
+		for _, r := range remove {
+			if equality.Semantic.DeepEqual(v, r) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func removeMounts(mounts, remove []v1.VolumeMount) []v1.VolumeMount {
+	var res []v1.VolumeMount
+	for _, m := range mounts {
+		found := false
+		for _, r := range remove {
+			if equality.Semantic.DeepEqual(m, r) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+// importedSecurityContext returns the security context of a container, or nil
+// if it's the one the container gets by default. A container without one is
+// given an empty security context, so that it does not get the default one.
+func importedSecurityContext(sc, base *v1.SecurityContext) *v1.SecurityContext {
+	def := base
+	if def == nil {
+		def = securityContext(nil)
+	}
+	if equality.Semantic.DeepEqual(sc, def) {
+		return nil
+	}
+	if sc == nil {
+		return &v1.SecurityContext{}
+	}
+	return sc
+}
+
+// importResources returns the resource preset the container resources come
+// from. The main container gets the default preset when it has none, and the
+// sidecars keep their resources. The resources that do not match any preset
+// are added as a preset of the file with the given name.
+func importResources(jobsConfig *spec.JobsConfig, presets map[string]v1.ResourceRequirements,
+	resources v1.ResourceRequirements, name string, main bool) string {
+	def := v1.ResourceRequirements{}
+	if p, ok := presets["default"]; ok && main {
+		def = p
+	}
+	if equality.Semantic.DeepEqual(resources, def) {
+		return ""
+	}
+	for _, presets := range []map[string]v1.ResourceRequirements{presets, jobsConfig.ResourcePresets} {
+		for _, n := range sortedKeys(presets) {
+			if equality.Semantic.DeepEqual(resources, presets[n]) {
+				return n
+			}
+		}
+	}
+	if jobsConfig.ResourcePresets == nil {
+		jobsConfig.ResourcePresets = map[string]v1.ResourceRequirements{}
+	}
+	if equality.Semantic.DeepEqual(resources, v1.ResourceRequirements{}) {
+		name = "none"
+	}
+	jobsConfig.ResourcePresets[name] = resources
+	return name
+}
+
+// hoistCommonFields moves the fields shared by all the jobs to the file. The
+// most common value of the scalar fields set by all the jobs is moved to the
+// file, and the jobs with a different value keep overriding it.
+func hoistCommonFields(jobsConfig *spec.JobsConfig) {
+	jobs := jobsConfig.Jobs
+	if len(jobs) == 0 {
+		return
+	}
+	for _, field := range []func(*spec.CommonConfig) *string{
+		func(c *spec.CommonConfig) *string { return &c.Image },
+		func(c *spec.CommonConfig) *string { return &c.ImagePullPolicy },
+		func(c *spec.CommonConfig) *string { return &c.Cluster },
+		func(c *spec.CommonConfig) *string { return &c.ServiceAccountName },
+	} {
+		counts := map[string]int{}
+		common := ""
+		for i := range jobs {
+			v := *field(&jobs[i].CommonConfig)
+			if v == "" {
+				common = ""
+				break
+			}
+			counts[v]++
+			if counts[v] > counts[common] {
+				common = v
+			}
+		}
+		if common == "" {
+			continue
+		}
+		*field(&jobsConfig.CommonConfig) = common
+		for i := range jobs {
+			if *field(&jobs[i].CommonConfig) == common {
+				*field(&jobs[i].CommonConfig) = ""
+			}
+		}
+	}
+
+	commonEnv := jobs[0].Env
+	commonLabels := copyMap(jobs[0].Labels)
+	commonAnnotations := copyMap(jobs[0].Annotations)
+	commonRequirements := sets.NewString(jobs[0].Requirements...)
+	for _, job := range jobs[1:] {
+		commonEnv = removeEnv(commonEnv, removeEnv(commonEnv, job.Env))
+		for k, v := range commonLabels {
+			if job.Labels[k] != v {
+				delete(commonLabels, k)
+			}
+		}
+		for k, v := range commonAnnotations {
+			if job.Annotations[k] != v {
+				delete(commonAnnotations, k)
+			}
+		}
+		commonRequirements = commonRequirements.Intersection(sets.NewString(job.Requirements...))
+	}
+	jobsConfig.Env = commonEnv
+	if len(commonLabels) != 0 {
+		jobsConfig.Labels = commonLabels
+	}
+	if len(commonAnnotations) != 0 {
+		jobsConfig.Annotations = commonAnnotations
+	}
+	for _, r := range jobs[0].Requirements {
+		if commonRequirements.Has(r) {
+			jobsConfig.Requirements = append(jobsConfig.Requirements, r)
+		}
+	}
+	for i := range jobs {
+		jobs[i].Env = removeEnv(jobs[i].Env, commonEnv)
+		for k := range commonLabels {
+			delete(jobs[i].Labels, k)
+		}
+		if len(jobs[i].Labels) == 0 {
+			jobs[i].Labels = nil
+		}
+		for k := range commonAnnotations {
+			delete(jobs[i].Annotations, k)
+		}
+		if len(jobs[i].Annotations) == 0 {
+			jobs[i].Annotations = nil
+		}
+		var requirements []string
+		for _, r := range jobs[i].Requirements {
+			if !commonRequirements.Has(r) {
+				requirements = append(requirements, r)
+			}
+		}
+		jobs[i].Requirements = requirements
+	}
+}
+
+// roundTrip converts the imported meta config the same way as the meta config
+// files, and compares the result with the Prow jobs it was imported from.
+func (cli *Client) roundTrip(path string, jobsConfig spec.JobsConfig, g *importGroup) ([]JobDiff, error) {
+	bs, err := yaml.Marshal(jobsConfig)
+	if err != nil {
+		return nil, err
+	}
+	raw := spec.JobsConfig{}
+	if err := yaml.UnmarshalStrict(bs, &raw); err != nil {
+		return nil, err
+	}
+	resolved, err := cli.resolveJobsConfig(path, raw)
+	if err != nil {
+		return nil, err
+	}
+	generated, err := cli.ConvertJobConfig(path, resolved, g.branch)
+	if err != nil {
+		return nil, err
+	}
+	return DiffJobConfigs(g.jobs, generated)
+}
+
+func copyMap(m map[string]string) map[string]string {
+	res := make(map[string]string, len(m))
+	for k, v := range m {
+		res[k] = v
+	}
+	return res
+}
+
+func sortedKeys(m interface{}) []string {
+	keys := reflect.ValueOf(m).MapKeys()
+	res := make([]string, 0, len(keys))
+	for _, k := range keys {
+		res = append(res, k.String())
+	}
+	sort.Strings(res)
+	return res
+}
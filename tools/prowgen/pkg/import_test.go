@@ -0,0 +1,261 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/test-infra/prow/config"
+	"sigs.k8s.io/yaml"
+)
+
+func TestImportRoundTrip(t *testing.T) {
+	bc, err := ReadBase(nil, "testdata/.base.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := &Client{BaseConfig: bc}
+	// The generated Prow jobs of the golden files are imported back.
+	files, err := filepath.Glob("testdata/*.gen.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if file == "testdata/testgrid.gen.yaml" {
+			continue
+		}
+		t.Run(file, func(t *testing.T) {
+			jobs, err := ReadProwJobConfig(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			imported, err := cli.Import(jobs)
+			if err != nil {
+				t.Fatalf("Failed to import %s: %v", file, err)
+			}
+			if len(imported) == 0 {
+				t.Fatalf("Expected the jobs of %s to be imported", file)
+			}
+			for _, f := range imported {
+				if len(f.Diffs) != 0 {
+					t.Errorf("The jobs imported to %s do not round trip:\n%s", f.Path, FormatDiffs(f.Diffs))
+				}
+			}
+		})
+	}
+}
+
+func TestImport(t *testing.T) {
+	base := `node_selector:
+  testing: test-pool
+resources_presets:
+  default:
+    requests:
+      cpu: 1000m
+requirements: [cache]
+requirement_presets:
+  cache:
+    volumeMounts:
+    - mountPath: /home/prow/go/pkg
+      name: build-cache
+    volumes:
+    - hostPath:
+        path: /var/tmp/prow/cache
+      name: build-cache
+  gcp:
+    labels:
+      preset-service-account: "true"
+`
+	jobs := `presubmits:
+  istio/tools:
+  - name: lint_tools_release-1.14
+    always_run: true
+    branches: [^release-1.14$]
+    decorate: true
+    labels:
+      preset-service-account: "true"
+    spec:
+      containers:
+      - image: gcr.io/istio-testing/build-tools:release-1.14
+        command: [make, lint]
+        resources:
+          requests:
+            cpu: 1000m
+        securityContext:
+          privileged: true
+        volumeMounts:
+        - mountPath: /home/prow/go/pkg
+          name: build-cache
+        - mountPath: /etc/secret
+          name: secret
+      nodeSelector:
+        kubernetes.io/arch: amd64
+        testing: test-pool
+      volumes:
+      - hostPath:
+          path: /var/tmp/prow/cache
+        name: build-cache
+      - name: secret
+        secret:
+          secretName: secret
+postsubmits:
+  istio/tools:
+  - name: lint_tools_release-1.14_postsubmit
+    branches: [^release-1.14$]
+    decorate: true
+    labels:
+      preset-service-account: "true"
+    spec:
+      containers:
+      - image: gcr.io/istio-testing/build-tools:release-1.14
+        command: [make, lint]
+        resources:
+          requests:
+            cpu: 1000m
+        securityContext:
+          privileged: true
+        volumeMounts:
+        - mountPath: /home/prow/go/pkg
+          name: build-cache
+        - mountPath: /etc/secret
+          name: secret
+      nodeSelector:
+        kubernetes.io/arch: amd64
+        testing: test-pool
+      volumes:
+      - hostPath:
+          path: /var/tmp/prow/cache
+        name: build-cache
+      - name: secret
+        secret:
+          secretName: secret
+  - name: release_tools_release-1.14_postsubmit
+    branches: [^release-1.14$]
+    decorate: true
+    spec:
+      containers:
+      - image: gcr.io/istio-testing/build-tools:release-1.14
+        command: [make, release]
+        resources:
+          requests:
+            cpu: 8000m
+        securityContext:
+          privileged: true
+      hostNetwork: true
+      nodeSelector:
+        kubernetes.io/arch: amd64
+        testing: test-pool
+`
+	want := `branches:
+- release-1.14
+image: gcr.io/istio-testing/build-tools:release-1.14
+jobs:
+- command:
+  - make
+  - lint
+  name: lint
+  requirements:
+  - gcp
+  - lint
+- command:
+  - make
+  - release
+  excluded_requirements:
+  - cache
+  name: release
+  resources: release
+  types:
+  - postsubmit
+org: istio
+repo: tools
+requirement_presets:
+  lint:
+    volumeMounts:
+    - mountPath: /etc/secret
+      name: secret
+    volumes:
+    - name: secret
+      secret:
+        secretName: secret
+resources_presets:
+  release:
+    requests:
+      cpu: "8"
+`
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{".base.yaml": base})
+	bc, err := ReadBase(nil, filepath.Join(dir, ".base.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobConfig := config.JobConfig{}
+	if err := yaml.Unmarshal([]byte(jobs), &jobConfig); err != nil {
+		t.Fatal(err)
+	}
+	cli := &Client{BaseConfig: bc}
+	imported, err := cli.Import(jobConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported) != 1 || imported[0].Path != "tools-1.14.yaml" {
+		t.Fatalf("Expected the jobs to be imported to tools-1.14.yaml, got %+v", imported)
+	}
+	got, err := yaml.Marshal(imported[0].JobsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Fatalf("Imported meta config does not match, (-want, +got): \n%s", diff)
+	}
+	// The host network cannot be configured in the meta config, so the round
+	// trip reports it.
+	wantDiffs := []JobDiff{{
+		Type:    TypePostsubmit,
+		OrgRepo: "istio/tools",
+		Name:    "release_tools_release-1.14_postsubmit",
+		Change:  ChangeModified,
+		Fields:  []FieldDiff{{Path: "spec.hostNetwork", Old: "true"}},
+	}}
+	if diff := cmp.Diff(wantDiffs, imported[0].Diffs); diff != "" {
+		t.Fatalf("Round trip differences do not match, (-want, +got): \n%s", diff)
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		// A meta config file is not a Prow job config.
+		"meta.yaml": `org: istio
+repo: istio
+jobs:
+- name: unit
+  command: [make, test]
+`,
+		"empty.yaml": `presubmits: {}
+`,
+	})
+	if _, err := ReadProwJobConfig(filepath.Join(dir, "meta.yaml")); err == nil {
+		t.Fatal("Expected an error for a meta config file, but did not receive one")
+	}
+	jobs, err := ReadProwJobConfig(filepath.Join(dir, "empty.yaml"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := (&Client{}).Import(jobs); err == nil {
+		t.Fatal("Expected an error when there is no Prow job to import, but did not receive one")
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/hashicorp/go-multierror"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/yaml"
+
+	"istio.io/test-infra/tools/prowgen/pkg/spec"
+)
+
+// readImports reads the preset libraries imported by the base config file, in
+// order, and returns their presets as a common config. The same preset can be
+// defined by multiple libraries, e.g. if a library is imported twice, only if
+// the definitions are equal.
+func readImports(baseFile string, imports []string) (spec.CommonConfig, error) {
+	res := spec.CommonConfig{}
+	// The libraries that define each preset, to report the conflicts.
+	resourceOrigins := map[string]string{}
+	requirementOrigins := map[string]string{}
+
+	var err error
+	for i, file := range importPaths(baseFile, imports) {
+		imp := imports[i]
+		lib, e := readPresetLibrary(file)
+		if e != nil {
+			err = multierror.Append(err, e)
+			continue
+		}
+
+		for _, name := range sets.StringKeySet(lib.ResourcePresets).List() {
+			preset := lib.ResourcePresets[name]
+			if origin, ok := resourceOrigins[name]; ok && !equality.Semantic.DeepEqual(res.ResourcePresets[name], preset) {
+				err = multierror.Append(err, &ValidationError{File: baseFile,
+					Message: fmt.Sprintf("resource preset %q of import %q conflicts with the one of import %q", name, imp, origin)})
+			} else if !ok {
+				resourceOrigins[name] = imp
+			}
+		}
+		for _, name := range sets.StringKeySet(lib.RequirementPresets).List() {
+			preset := lib.RequirementPresets[name]
+			if origin, ok := requirementOrigins[name]; ok && !equality.Semantic.DeepEqual(res.RequirementPresets[name], preset) {
+				err = multierror.Append(err, &ValidationError{File: baseFile,
+					Message: fmt.Sprintf("requirement preset %q of import %q conflicts with the one of import %q", name, imp, origin)})
+			} else if !ok {
+				requirementOrigins[name] = imp
+			}
+		}
+		res = mergeCommonConfig(res, spec.CommonConfig{
+			ResourcePresets:    lib.ResourcePresets,
+			RequirementPresets: lib.RequirementPresets,
+		})
+	}
+	return res, err
+}
+
+// readPresetLibrary reads the preset library file.
+func readPresetLibrary(file string) (spec.PresetLibrary, error) {
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return spec.PresetLibrary{}, &ValidationError{File: file, Message: fmt.Sprintf("failed to read: %v", err)}
+	}
+	lib := spec.PresetLibrary{}
+	if err := yaml.UnmarshalStrict(bs, &lib, yaml.DisallowUnknownFields); err != nil {
+		return spec.PresetLibrary{}, yamlError(file, err)
+	}
+	return lib, nil
+}
+
+// importPaths returns the paths of the files imported by the base config file.
+func importPaths(baseFile string, imports []string) []string {
+	res := make([]string, 0, len(imports))
+	for _, imp := range imports {
+		if filepath.IsAbs(imp) {
+			res = append(res, filepath.Clean(imp))
+		} else {
+			res = append(res, filepath.Join(filepath.Dir(baseFile), imp))
+		}
+	}
+	return res
+}
@@ -0,0 +1,120 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestReadBaseImports(t *testing.T) {
+	libraries := map[string]string{
+		"presets.yaml": `resources_presets:
+  default:
+    requests:
+      cpu: 1000m
+  large:
+    requests:
+      cpu: 8000m
+requirement_presets:
+  cache:
+    env:
+    - name: CACHE
+      value: "true"
+`,
+		"kind.yaml": `requirement_presets:
+  kind:
+    env:
+    - name: KIND
+      value: "true"
+  cache:
+    env:
+    - name: CACHE
+      value: "true"
+`,
+		"conflict.yaml": `resources_presets:
+  large:
+    requests:
+      cpu: 16000m
+`,
+		"unknown-field.yaml": `image: gcr.io/istio-testing/build-tools:master
+`,
+	}
+	tests := []struct {
+		name         string
+		base         string
+		resources    []string
+		requirements []string
+		expectError  bool
+	}{
+		{
+			name: "imports",
+			base: `imports: [presets.yaml, kind.yaml]
+resources_presets:
+  default:
+    requests:
+      cpu: 2000m
+`,
+			resources:    []string{"default", "large"},
+			requirements: []string{"cache", "kind"},
+		},
+		{
+			name:        "conflict",
+			base:        `imports: [presets.yaml, conflict.yaml]`,
+			expectError: true,
+		},
+		{
+			name:        "nonexistent",
+			base:        `imports: [nonexistent.yaml]`,
+			expectError: true,
+		},
+		{
+			name:        "not a preset library",
+			base:        `imports: [unknown-field.yaml]`,
+			expectError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestFiles(t, dir, libraries)
+			writeTestFiles(t, dir, map[string]string{".base.yaml": tt.base})
+
+			bc, err := ReadBase(nil, filepath.Join(dir, ".base.yaml"))
+			if tt.expectError {
+				if err == nil {
+					t.Fatalf("Test %q expected an error, but did not receive one", tt.name)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("Test %q did not expect an error, but received %v", tt.name, err)
+			}
+			if diff := cmp.Diff(tt.resources, sets.StringKeySet(bc.ResourcePresets).List()); diff != "" {
+				t.Fatalf("Resource presets do not match, (-want, +got): \n%s", diff)
+			}
+			if diff := cmp.Diff(tt.requirements, sets.StringKeySet(bc.RequirementPresets).List()); diff != "" {
+				t.Fatalf("Requirement presets do not match, (-want, +got): \n%s", diff)
+			}
+			// The presets of the .base.yaml file override the imported ones.
+			requests := bc.ResourcePresets["default"].Requests
+			if got := requests.Cpu().String(); got != "2" {
+				t.Fatalf("Expected the default preset of the base config, got cpu %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/hashicorp/go-multierror"
+	"k8s.io/test-infra/prow/config"
+
+	"istio.io/test-infra/tools/prowgen/pkg/spec"
+)
+
+const (
+	LayoutBranch = "branch"
+	LayoutRepo   = "repo"
+	LayoutSingle = "single"
+	LayoutType   = "type"
+)
+
+// OutputRef identifies the Prow jobs of a type generated for an
+// org/repo:branch.
+type OutputRef struct {
+	Org    string
+	Repo   string
+	Branch string
+	// Type is one of presubmit, postsubmit and periodic.
+	Type string
+}
+
+// OutputLayout returns the path of the file, relative to the output
+// directory, that the Prow jobs of the ref are written to.
+type OutputLayout func(ref OutputRef) (string, error)
+
+var outputLayouts = map[string]OutputLayout{
+	LayoutBranch: func(ref OutputRef) (string, error) {
+		return path.Join(ref.Org, ref.Repo, fmt.Sprintf("%s.%s.%s.gen.yaml", ref.Org, ref.Repo, ref.Branch)), nil
+	},
+	LayoutRepo: func(ref OutputRef) (string, error) {
+		return path.Join(ref.Org, ref.Repo, fmt.Sprintf("%s.%s.gen.yaml", ref.Org, ref.Repo)), nil
+	},
+	LayoutSingle: func(ref OutputRef) (string, error) {
+		return "jobs.gen.yaml", nil
+	},
+	LayoutType: func(ref OutputRef) (string, error) {
+		return path.Join(ref.Org, ref.Repo, fmt.Sprintf("%s.%s.%s.%s.gen.yaml", ref.Org, ref.Repo, ref.Branch, ref.Type)), nil
+	},
+}
+
+// RegisterOutputLayout registers the layout with the name, so that it can be
+// used in the output_layout of the base config, e.g. by the tools using prowgen
+// as a library.
+func RegisterOutputLayout(name string, layout OutputLayout) {
+	outputLayouts[name] = layout
+}
+
+// OutputLayouts returns the names of all the registered layouts, sorted.
+func OutputLayouts() []string {
+	res := make([]string, 0, len(outputLayouts))
+	for name := range outputLayouts {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// NewOutputLayout returns the layout configured in the base config. The paths
+// it returns are checked to be relative paths inside the output directory.
+func NewOutputLayout(cfg spec.OutputLayout) (OutputLayout, error) {
+	var layout OutputLayout
+	switch {
+	case cfg.Name != "" && cfg.Template != "":
+		return nil, errors.New("output_layout name and template cannot be both set")
+	case cfg.Template != "":
+		tmpl, err := template.New("output_layout").Option("missingkey=error").Parse(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output_layout template: %v", err)
+		}
+		layout = func(ref OutputRef) (string, error) {
+			sb := strings.Builder{}
+			if err := tmpl.Execute(&sb, ref); err != nil {
+				return "", fmt.Errorf("invalid output_layout template: %v", err)
+			}
+			return sb.String(), nil
+		}
+	default:
+		name := cfg.Name
+		if name == "" {
+			name = LayoutBranch
+		}
+		var ok bool
+		if layout, ok = outputLayouts[name]; !ok {
+			return nil, fmt.Errorf("unknown output_layout %q, must be one of %s", name, strings.Join(OutputLayouts(), ", "))
+		}
+	}
+
+	return func(ref OutputRef) (string, error) {
+		p, err := layout(ref)
+		if err != nil {
+			return "", err
+		}
+		if p == "" || path.IsAbs(p) || path.Clean(p) == ".." || strings.HasPrefix(path.Clean(p), "../") {
+			return "", fmt.Errorf("output_layout path %q of %s/%s:%s must be relative to the output directory", p, ref.Org, ref.Repo, ref.Branch)
+		}
+		return path.Clean(p), nil
+	}, nil
+}
+
+// LayoutFiles returns the paths of the files the Prow jobs of the
+// org/repo:branch are written to, and the part of the jobs written to each
+// file. The jobs are only split by type if the layout returns different paths
+// for the types, and the files of the types without any job are skipped
+// then.
+func LayoutFiles(layout OutputLayout, org, repo, branch string, jobs config.JobConfig) ([]string, []config.JobConfig, error) {
+	paths := make([]string, 0)
+	types := map[string][]string{}
+	for _, t := range []string{TypePresubmit, TypePostsubmit, TypePeriodic} {
+		p, err := layout(OutputRef{Org: org, Repo: repo, Branch: branch, Type: t})
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := types[p]; !ok {
+			paths = append(paths, p)
+		}
+		types[p] = append(types[p], t)
+	}
+
+	resPaths := make([]string, 0, len(paths))
+	resJobs := make([]config.JobConfig, 0, len(paths))
+	for _, p := range paths {
+		part := config.JobConfig{
+			PresubmitsStatic:  map[string][]config.Presubmit{},
+			PostsubmitsStatic: map[string][]config.Postsubmit{},
+			Periodics:         []config.Periodic{},
+		}
+		empty := true
+		for _, t := range types[p] {
+			switch t {
+			case TypePresubmit:
+				part.PresubmitsStatic = jobs.PresubmitsStatic
+				empty = empty && len(jobs.PresubmitsStatic) == 0
+			case TypePostsubmit:
+				part.PostsubmitsStatic = jobs.PostsubmitsStatic
+				empty = empty && len(jobs.PostsubmitsStatic) == 0
+			case TypePeriodic:
+				part.Periodics = jobs.Periodics
+				empty = empty && len(jobs.Periodics) == 0
+			}
+		}
+		if empty && len(paths) > 1 {
+			continue
+		}
+		resPaths = append(resPaths, p)
+		resJobs = append(resJobs, part)
+	}
+	return resPaths, resJobs, nil
+}
+
+// ValidateOutputBasenames checks that the files the Prow jobs are written to
+// have unique base names, since Prow does not load duplicated base names from
+// the job config directory.
+func ValidateOutputBasenames(paths []string) error {
+	var err error
+	basenames := map[string]string{}
+	for _, p := range paths {
+		base := filepath.Base(p)
+		if other, ok := basenames[base]; ok {
+			err = multierror.Append(err, fmt.Errorf("output_layout paths %s and %s have the same base name, "+
+				"which Prow does not allow", other, p))
+			continue
+		}
+		basenames[base] = p
+	}
+	return err
+}
+
+// CombineJobConfigs returns a job config with the Prow jobs of jc1 followed by
+// the ones of jc2. Neither of them is modified.
+func CombineJobConfigs(jc1, jc2 config.JobConfig) config.JobConfig {
+	res := config.JobConfig{
+		PresubmitsStatic:  map[string][]config.Presubmit{},
+		PostsubmitsStatic: map[string][]config.Postsubmit{},
+		Periodics:         []config.Periodic{},
+	}
+	for _, jc := range []config.JobConfig{jc1, jc2} {
+		for orgRepo, jobs := range jc.PresubmitsStatic {
+			res.PresubmitsStatic[orgRepo] = append(res.PresubmitsStatic[orgRepo], jobs...)
+		}
+		for orgRepo, jobs := range jc.PostsubmitsStatic {
+			res.PostsubmitsStatic[orgRepo] = append(res.PostsubmitsStatic[orgRepo], jobs...)
+		}
+		res.Periodics = append(res.Periodics, jc.Periodics...)
+	}
+	return res
+}
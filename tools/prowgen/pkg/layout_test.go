@@ -0,0 +1,145 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/test-infra/prow/config"
+
+	"istio.io/test-infra/tools/prowgen/pkg/spec"
+)
+
+func TestOutputLayout(t *testing.T) {
+	jobs := config.JobConfig{
+		PresubmitsStatic: map[string][]config.Presubmit{
+			"istio/istio": {{JobBase: config.JobBase{Name: "unit"}}},
+		},
+		PostsubmitsStatic: map[string][]config.Postsubmit{
+			"istio/istio": {{JobBase: config.JobBase{Name: "unit"}}},
+		},
+		Periodics: []config.Periodic{},
+	}
+	tests := []struct {
+		name        string
+		layout      spec.OutputLayout
+		paths       []string
+		expectError bool
+	}{
+		{
+			name:   "default",
+			layout: spec.OutputLayout{},
+			paths:  []string{"istio/istio/istio.istio.master.gen.yaml"},
+		},
+		{
+			name:   "repo",
+			layout: spec.OutputLayout{Name: LayoutRepo},
+			paths:  []string{"istio/istio/istio.istio.gen.yaml"},
+		},
+		{
+			name:   "single",
+			layout: spec.OutputLayout{Name: LayoutSingle},
+			paths:  []string{"jobs.gen.yaml"},
+		},
+		{
+			name:   "type",
+			layout: spec.OutputLayout{Name: LayoutType},
+			// There is no file for the periodic jobs since there is none.
+			paths: []string{
+				"istio/istio/istio.istio.master.presubmit.gen.yaml",
+				"istio/istio/istio.istio.master.postsubmit.gen.yaml",
+			},
+		},
+		{
+			name:   "template",
+			layout: spec.OutputLayout{Template: "{{.Org}}/{{.Branch}}/{{.Repo}}.yaml"},
+			paths:  []string{"istio/master/istio.yaml"},
+		},
+		{
+			name:        "unknown",
+			layout:      spec.OutputLayout{Name: "unknown"},
+			expectError: true,
+		},
+		{
+			name:        "name and template",
+			layout:      spec.OutputLayout{Name: LayoutRepo, Template: "{{.Repo}}.yaml"},
+			expectError: true,
+		},
+		{
+			name:        "unknown field",
+			layout:      spec.OutputLayout{Template: "{{.Cluster}}.yaml"},
+			expectError: true,
+		},
+		{
+			name:        "outside of the output directory",
+			layout:      spec.OutputLayout{Template: "../{{.Repo}}.yaml"},
+			expectError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layout, err := NewOutputLayout(tt.layout)
+			var paths []string
+			if err == nil {
+				paths, _, err = LayoutFiles(layout, "istio", "istio", "master", jobs)
+			}
+			if tt.expectError {
+				if err == nil {
+					t.Fatalf("Test %q expected an error, but did not receive one", tt.name)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("Test %q did not expect an error, but received %v", tt.name, err)
+			}
+			if diff := cmp.Diff(tt.paths, paths); diff != "" {
+				t.Fatalf("Paths do not match, (-want, +got): \n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValidateOutputBasenames(t *testing.T) {
+	tests := []struct {
+		name        string
+		paths       []string
+		expectError bool
+	}{
+		{
+			name:  "unique",
+			paths: []string{"istio/istio/istio.istio.master.gen.yaml", "istio/proxy/istio.proxy.master.gen.yaml"},
+		},
+		{
+			name:        "same base name in different directories",
+			paths:       []string{"istio/istio/presubmit.yaml", "istio/proxy/presubmit.yaml"},
+			expectError: true,
+		},
+		{
+			name:        "same repo in different orgs",
+			paths:       []string{"istio/istio.presubmit.yaml", "istio-private/istio.presubmit.yaml"},
+			expectError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateOutputBasenames(tt.paths)
+			if tt.expectError && err == nil {
+				t.Fatalf("Test %q expected an error, but did not receive one", tt.name)
+			} else if !tt.expectError && err != nil {
+				t.Fatalf("Test %q did not expect an error, but received %v", tt.name, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/test-infra/prow/config"
+
+	"istio.io/test-infra/tools/prowgen/pkg/spec"
+)
+
+const (
+	// NeedsAnnotation lists the Prow jobs that must succeed before the
+	// annotated Prow job is triggered.
+	NeedsAnnotation = "prowgen.istio.io/needs"
+	// NeededByAnnotation lists the Prow jobs to trigger once the annotated
+	// Prow job succeeded.
+	NeededByAnnotation = "prowgen.istio.io/needed-by"
+)
+
+// jobTypes returns the types of the Prow jobs generated for the job.
+func jobTypes(job spec.Job) []string {
+	if len(job.Types) == 0 {
+		return []string{TypePresubmit, TypePostsubmit}
+	}
+	return job.Types
+}
+
+// validateNeeds checks that the needs of the jobs reference the other jobs of
+// the file, which are generated for all the types of the jobs needing them, and
+// that they form a DAG. The periodics cannot have needs, since the Prow jobs
+// with needs are left to be triggered by the downstream trigger, and a
+// periodic is always triggered by its schedule.
+func validateNeeds(fileName string, jobs []spec.Job) error {
+	var err error
+	byName := map[string]spec.Job{}
+	for _, job := range jobs {
+		if _, ok := byName[job.Name]; !ok {
+			byName[job.Name] = job
+		}
+	}
+	for _, job := range jobs {
+		if len(job.Needs) == 0 {
+			continue
+		}
+		if sets.NewString(jobTypes(job)...).Has(TypePeriodic) {
+			err = multierror.Append(err, jobError(fileName, job.Name,
+				"needs is not supported for periodic jobs, they are triggered by their schedule"))
+		}
+		if job.Regex != "" || len(job.RunIfChanged) != 0 || len(job.SkipIfOnlyChanged) != 0 {
+			err = multierror.Append(err, jobError(fileName, job.Name,
+				"needs cannot be set together with regex, run_if_changed or skip_if_only_changed, "+
+					"the job is only triggered after the jobs it needs"))
+		}
+		for _, n := range job.Needs {
+			needed, ok := byName[n]
+			switch {
+			case n == job.Name:
+				err = multierror.Append(err, jobError(fileName, job.Name, "job cannot need itself"))
+			case !ok:
+				err = multierror.Append(err, jobError(fileName, job.Name, "needs nonexistent job %q", n))
+			default:
+				if missing := sets.NewString(jobTypes(job)...).Difference(sets.NewString(jobTypes(needed)...)); missing.Len() != 0 {
+					err = multierror.Append(err, jobError(fileName, job.Name, "needs job %q, which is not generated for %v jobs", n, missing.List()))
+				}
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// The jobs whose needs are all checked to not lead to a cycle.
+	done := sets.NewString()
+	var visit func(name string, chain []string) error
+	visit = func(name string, chain []string) error {
+		if done.Has(name) {
+			return nil
+		}
+		for i, c := range chain {
+			if c == name {
+				return jobError(fileName, chain[0], "needs cycle: %s", strings.Join(append(chain[i:], name), " -> "))
+			}
+		}
+		for _, n := range byName[name].Needs {
+			if e := visit(n, append(chain, name)); e != nil {
+				return e
+			}
+		}
+		done.Insert(name)
+		return nil
+	}
+	for _, job := range jobs {
+		if e := visit(job.Name, nil); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// annotateNeeds annotates the Prow jobs of a type with the Prow jobs of the
+// same type they need and are needed by. parents are the names of the jobs the
+// Prow jobs are generated from.
+func annotateNeeds(jobs []spec.Job, bases []*config.JobBase, parents []string) {
+	generated := map[string][]string{}
+	for i, jb := range bases {
+		generated[parents[i]] = append(generated[parents[i]], jb.Name)
+	}
+	needs := map[string][]string{}
+	neededBy := map[string][]string{}
+	for _, job := range jobs {
+		if _, ok := needs[job.Name]; ok {
+			continue
+		}
+		needs[job.Name] = job.Needs
+		for _, n := range job.Needs {
+			neededBy[n] = append(neededBy[n], job.Name)
+		}
+	}
+
+	prowJobs := func(names []string) string {
+		res := make([]string, 0)
+		for _, n := range names {
+			res = append(res, generated[n]...)
+		}
+		return strings.Join(res, ",")
+	}
+	for i, jb := range bases {
+		annotations := map[string]string{}
+		if v := prowJobs(needs[parents[i]]); v != "" {
+			annotations[NeedsAnnotation] = v
+		}
+		if v := prowJobs(neededBy[parents[i]]); v != "" {
+			annotations[NeededByAnnotation] = v
+		}
+		if len(annotations) == 0 {
+			continue
+		}
+		// The annotations can be shared with the other Prow jobs generated
+		// from the same job.
+		for k, v := range jb.Annotations {
+			annotations[k] = v
+		}
+		jb.Annotations = annotations
+	}
+}
+
+// NeedsGraph returns the graph of the needs of the jobs in the DOT format, with
+// a cluster for each meta config file, keyed by the file names, that has any
+// job with needs.
+func NeedsGraph(configs map[string]spec.JobsConfig) string {
+	files := make([]string, 0, len(configs))
+	for file := range configs {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	sb := strings.Builder{}
+	sb.WriteString("digraph needs {\n")
+	for i, file := range files {
+		jc := configs[file]
+		hasNeeds := false
+		for _, job := range jc.Jobs {
+			hasNeeds = hasNeeds || len(job.Needs) != 0
+		}
+		if !hasNeeds {
+			continue
+		}
+		node := func(name string) string {
+			return fmt.Sprintf("%q", file+":"+name)
+		}
+		sb.WriteString(fmt.Sprintf("  subgraph cluster_%d {\n", i))
+		sb.WriteString(fmt.Sprintf("    label=%q;\n", fmt.Sprintf("%s/%s (%s)", jc.Org, jc.Repo, file)))
+		for _, job := range jc.Jobs {
+			sb.WriteString(fmt.Sprintf("    %s [label=%q];\n", node(job.Name), job.Name))
+		}
+		sb.WriteString("  }\n")
+		for _, job := range jc.Jobs {
+			for _, n := range job.Needs {
+				sb.WriteString(fmt.Sprintf("  %s -> %s;\n", node(n), node(job.Name)))
+			}
+		}
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
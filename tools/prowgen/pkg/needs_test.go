@@ -0,0 +1,105 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"istio.io/test-infra/tools/prowgen/pkg/spec"
+)
+
+func TestNeedsGraph(t *testing.T) {
+	cli := &Client{}
+	jobs, err := cli.ReadJobsConfig("testdata/needs.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read the jobs config: %v", err)
+	}
+	simple, err := cli.ReadJobsConfig("testdata/simple.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read the jobs config: %v", err)
+	}
+
+	got := NeedsGraph(map[string]spec.JobsConfig{"needs.yaml": jobs, "simple.yaml": simple})
+	want := `digraph needs {
+  subgraph cluster_0 {
+    label="istio/istio (needs.yaml)";
+    "needs.yaml:build" [label="build"];
+    "needs.yaml:integ-$(matrix.suite)" [label="integ-$(matrix.suite)"];
+    "needs.yaml:release" [label="release"];
+    "needs.yaml:e2e" [label="e2e"];
+  }
+  "needs.yaml:build" -> "needs.yaml:integ-$(matrix.suite)";
+  "needs.yaml:build" -> "needs.yaml:release";
+  "needs.yaml:build" -> "needs.yaml:e2e";
+  "needs.yaml:integ-$(matrix.suite)" -> "needs.yaml:e2e";
+}
+`
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("Graph does not match, (-want, +got): \n%s", diff)
+	}
+}
+
+func TestNeedsTrigger(t *testing.T) {
+	cli := &Client{}
+	file := "testdata/needs.yaml"
+	jobs, err := cli.ReadJobsConfig(file)
+	if err != nil {
+		t.Fatalf("Failed to read the jobs config: %v", err)
+	}
+	output, err := cli.ConvertJobConfig(file, jobs, "master")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The Prow jobs with needs are left to the downstream trigger.
+	for _, presubmit := range output.PresubmitsStatic["istio/istio"] {
+		_, hasNeeds := presubmit.Annotations[NeedsAnnotation]
+		if presubmit.AlwaysRun == hasNeeds {
+			t.Errorf("Prow job %q with needs %v has always_run %v", presubmit.Name, hasNeeds, presubmit.AlwaysRun)
+		}
+		if hasNeeds && presubmit.RunIfChanged != "" {
+			t.Errorf("Prow job %q with needs has run_if_changed %q", presubmit.Name, presubmit.RunIfChanged)
+		}
+	}
+	for _, postsubmit := range output.PostsubmitsStatic["istio/istio"] {
+		_, hasNeeds := postsubmit.Annotations[NeedsAnnotation]
+		if alwaysRun := postsubmit.AlwaysRun == nil || *postsubmit.AlwaysRun; alwaysRun == hasNeeds {
+			t.Errorf("Prow job %q with needs %v has always_run %v", postsubmit.Name, hasNeeds, alwaysRun)
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		job  spec.Job
+	}{
+		{
+			name: "periodic",
+			job:  spec.Job{Name: "release", Types: []string{TypePeriodic}, Needs: []string{"build"}},
+		},
+		{
+			name: "regex",
+			job: spec.Job{Name: "release", Types: []string{TypePresubmit}, Needs: []string{"build"},
+				CommonConfig: spec.CommonConfig{Regex: `\.go$`}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			build := spec.Job{Name: "build", Types: []string{TypePresubmit, TypePostsubmit, TypePeriodic}}
+			if err := validateNeeds("test.yaml", []spec.Job{build, tt.job}); err == nil {
+				t.Fatalf("Test %q expected an error, but did not receive one", tt.name)
+			}
+		})
+	}
+}
@@ -40,13 +40,22 @@ func WriteJobsConfig(jobsConfig spec.JobsConfig, file string) error {
 
 // Write will write the generated Prow jobs to the given file.
 func Write(jobs config.JobConfig, fname, header string) error {
-	bs, err := yaml.Marshal(jobs)
+	return write(jobs, fname, header)
+}
+
+// WriteTestgridConfig will write the generated TestGrid config to the given file.
+func WriteTestgridConfig(testgridConfig TestgridOutput, fname, header string) error {
+	return write(testgridConfig, fname, header)
+}
+
+func write(obj interface{}, fname, header string) error {
+	bs, err := yaml.Marshal(obj)
 	if err != nil {
-		log.Fatalf("Failed to marshal result: %v", err)
+		return fmt.Errorf("failed to marshal result: %v", err)
 	}
 	dir := filepath.Dir(fname)
 	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
-		log.Fatalf("failed to create directory %q: %v", dir, err)
+		return fmt.Errorf("failed to create directory %q: %v", dir, err)
 	}
 	if header == "" {
 		header = DefaultAutogenHeader
@@ -58,12 +67,22 @@ func Write(jobs config.JobConfig, fname, header string) error {
 
 // Check will diff the generated config file and the current config file.
 func Check(jobs config.JobConfig, currentConfigFile string, header string) error {
+	return check(jobs, currentConfigFile, header)
+}
+
+// CheckTestgridConfig will diff the generated TestGrid config and the current
+// TestGrid config file.
+func CheckTestgridConfig(testgridConfig TestgridOutput, currentConfigFile string, header string) error {
+	return check(testgridConfig, currentConfigFile, header)
+}
+
+func check(obj interface{}, currentConfigFile string, header string) error {
 	current, err := ioutil.ReadFile(currentConfigFile)
 	if err != nil {
 		return fmt.Errorf("failed to read current config for %s: %v", currentConfigFile, err)
 	}
 
-	newConfig, err := yaml.Marshal(jobs)
+	newConfig, err := yaml.Marshal(obj)
 	if err != nil {
 		return fmt.Errorf("failed to marshal result: %v", err)
 	}
@@ -0,0 +1,174 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/test-infra/prow/config"
+
+	"istio.io/test-infra/tools/prowgen/pkg/spec"
+)
+
+// defaultCluster is the cluster Prow schedules the jobs that do not set one in.
+const defaultCluster = "default"
+
+// validatePolicies validates the policies defined in a .base.yaml file.
+func validatePolicies(file string, policies []spec.Policy) error {
+	var err error
+	names := sets.NewString()
+	for _, p := range policies {
+		policyErr := func(format string, args ...interface{}) {
+			err = multierror.Append(err, &ValidationError{
+				File:    file,
+				Message: fmt.Sprintf("policy %q: ", p.Name) + fmt.Sprintf(format, args...),
+			})
+		}
+		if p.Name == "" {
+			policyErr("name must be set")
+		} else if names.Has(p.Name) {
+			policyErr("defined multiple times")
+		}
+		names.Insert(p.Name)
+		for _, t := range p.Match.Types {
+			if e := validate(t, sets.NewString(TypePresubmit, TypePostsubmit, TypePeriodic), "type"); e != nil {
+				policyErr("%v", e)
+			}
+		}
+		for _, re := range []struct{ field, regex string }{
+			{"match.repos", p.Match.Repos},
+			{"match.branches", p.Match.Branches},
+			{"match.names", p.Match.Names},
+			{"require.images", p.Require.Images},
+			{"require.repos", p.Require.Repos},
+		} {
+			if _, e := regexp.Compile(re.regex); e != nil {
+				policyErr("invalid %s regex: %v", re.field, e)
+			}
+		}
+		if reflect.DeepEqual(p.Require, spec.PolicyRequire{}) {
+			policyErr("require must be set")
+		}
+	}
+	return err
+}
+
+// mergePolicies adds the policies of a nested base config to the ones of its
+// parent. A policy with the same name as one of the parent replaces it.
+func mergePolicies(parent, child []spec.Policy) []spec.Policy {
+	names := sets.NewString()
+	for _, p := range child {
+		names.Insert(p.Name)
+	}
+	res := make([]spec.Policy, 0, len(parent)+len(child))
+	for _, p := range parent {
+		if !names.Has(p.Name) {
+			res = append(res, p)
+		}
+	}
+	return append(res, child...)
+}
+
+// checkPolicies checks the Prow job generated from the job against the
+// policies of the base config, and returns an error for each violation.
+func (cli *Client) checkPolicies(jobsConfig spec.JobsConfig, job spec.Job, jobType, branch string, base config.JobBase) error {
+	var err error
+	for _, p := range cli.BaseConfig.Policies {
+		if !policyMatches(p.Match, jobsConfig, job, jobType, branch, base) {
+			continue
+		}
+		for _, v := range policyViolations(p.Require, jobsConfig, base) {
+			msg := fmt.Sprintf("prow job %q violates policy %q", base.Name, p.Name)
+			if p.Description != "" {
+				msg += fmt.Sprintf(" (%s)", p.Description)
+			}
+			err = multierror.Append(err, fmt.Errorf("%s: %s", msg, v))
+		}
+	}
+	return err
+}
+
+// policyMatches returns whether the policy applies to the Prow job.
+func policyMatches(match spec.PolicyMatch, jobsConfig spec.JobsConfig, job spec.Job, jobType, branch string,
+	base config.JobBase) bool {
+	if len(match.Types) != 0 && !sets.NewString(match.Types...).Has(jobType) {
+		return false
+	}
+	if match.Repos != "" && !regexp.MustCompile(match.Repos).MatchString(jobsConfig.Org+"/"+jobsConfig.Repo) {
+		return false
+	}
+	if match.Branches != "" && !regexp.MustCompile(match.Branches).MatchString(branch) {
+		return false
+	}
+	if match.Names != "" && !regexp.MustCompile(match.Names).MatchString(base.Name) {
+		return false
+	}
+	if len(match.Requirements) != 0 {
+		applied := sets.NewString(job.Requirements...).Difference(sets.NewString(job.ExcludedRequirements...))
+		if !applied.HasAny(match.Requirements...) {
+			return false
+		}
+	}
+	if len(match.Clusters) != 0 && !sets.NewString(match.Clusters...).Has(jobCluster(base)) {
+		return false
+	}
+	return true
+}
+
+// policyViolations returns the requirements of a policy that the Prow job does
+// not satisfy.
+func policyViolations(require spec.PolicyRequire, jobsConfig spec.JobsConfig, base config.JobBase) []string {
+	var res []string
+	if require.Timeout && (base.DecorationConfig == nil || base.DecorationConfig.Timeout == nil) {
+		res = append(res, "timeout is not set")
+	}
+	if require.Images != "" && base.Spec != nil {
+		re := regexp.MustCompile(require.Images)
+		for _, c := range base.Spec.Containers {
+			if !re.MatchString(c.Image) {
+				res = append(res, fmt.Sprintf("image %q does not match %q", c.Image, require.Images))
+			}
+		}
+	}
+	cluster := jobCluster(base)
+	if len(require.Clusters) != 0 && !sets.NewString(require.Clusters...).Has(cluster) {
+		res = append(res, fmt.Sprintf("cluster %q is not one of %s", cluster, strings.Join(require.Clusters, ", ")))
+	}
+	if sets.NewString(require.ExcludedClusters...).Has(cluster) {
+		res = append(res, fmt.Sprintf("cluster %q is not allowed", cluster))
+	}
+	if require.Repos != "" && !regexp.MustCompile(require.Repos).MatchString(jobsConfig.Org+"/"+jobsConfig.Repo) {
+		res = append(res, fmt.Sprintf("repo %s/%s does not match %q", jobsConfig.Org, jobsConfig.Repo, require.Repos))
+	}
+	for _, l := range require.Labels {
+		if _, ok := base.Labels[l]; !ok {
+			res = append(res, fmt.Sprintf("label %q is not set", l))
+		}
+	}
+	return res
+}
+
+// jobCluster returns the cluster the Prow job is scheduled in.
+func jobCluster(base config.JobBase) string {
+	if base.Cluster == "" {
+		return defaultCluster
+	}
+	return base.Cluster
+}
@@ -0,0 +1,232 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPolicies(t *testing.T) {
+	base := `requirement_presets:
+  github:
+    env:
+    - name: GITHUB_TOKEN
+      value: token
+`
+	jobs := `org: istio
+repo: istio
+image: gcr.io/istio-testing/build-tools:master-2023-01-01T00-00-00
+branches: [master, release-1.14]
+jobs:
+- name: unit
+  command: [make, test]
+- name: release
+  types: [postsubmit]
+  command: [make, release]
+  timeout: 2h
+  requirements: [github]
+  cluster: test-infra-trusted
+- name: latest
+  types: [periodic]
+  cron: "0 0 * * *"
+  image: gcr.io/istio-testing/build-tools:latest
+  command: [make, latest]
+  requirements: [github]
+`
+	tests := []struct {
+		name        string
+		policies    string
+		violations  []string
+		expectError bool
+	}{
+		{
+			name: "release postsubmits set timeout",
+			policies: `- name: release-timeout
+  description: postsubmits on release branches must set timeout
+  match:
+    types: [postsubmit]
+    branches: ^release-
+  require:
+    timeout: true
+`,
+			violations: []string{
+				`prow job "unit_istio_release-1.14_postsubmit" violates policy "release-timeout" (postsubmits on release branches must set timeout): timeout is not set`,
+			},
+		},
+		{
+			name: "images pinned to a dated tag",
+			policies: `- name: dated-images
+  require:
+    images: :[a-z0-9.-]+-\d{4}-\d{2}-\d{2}T
+`,
+			violations: []string{
+				`prow job "latest_istio_periodic" violates policy "dated-images": image "gcr.io/istio-testing/build-tools:latest" does not match ":[a-z0-9.-]+-\\d{4}-\\d{2}-\\d{2}T"`,
+				`prow job "latest_istio_release-1.14_periodic" violates policy "dated-images": image "gcr.io/istio-testing/build-tools:latest" does not match ":[a-z0-9.-]+-\\d{4}-\\d{2}-\\d{2}T"`,
+			},
+		},
+		{
+			name: "github requirement in a trusted cluster",
+			policies: `- name: trusted-github
+  match:
+    requirements: [github]
+  require:
+    clusters: [test-infra-trusted]
+`,
+			violations: []string{
+				`prow job "latest_istio_periodic" violates policy "trusted-github": cluster "default" is not one of test-infra-trusted`,
+				`prow job "latest_istio_release-1.14_periodic" violates policy "trusted-github": cluster "default" is not one of test-infra-trusted`,
+			},
+		},
+		{
+			name: "presubmits not in a trusted cluster",
+			policies: `- name: untrusted-presubmits
+  match:
+    types: [presubmit]
+  require:
+    excluded_clusters: [test-infra-trusted]
+`,
+		},
+		{
+			name: "trusted jobs from a repo",
+			policies: `- name: trusted-repo
+  match:
+    clusters: [test-infra-trusted]
+  require:
+    repos: ^istio/test-infra$
+`,
+			violations: []string{
+				`prow job "release_istio_postsubmit" violates policy "trusted-repo": repo istio/istio does not match "^istio/test-infra$"`,
+				`prow job "release_istio_release-1.14_postsubmit" violates policy "trusted-repo": repo istio/istio does not match "^istio/test-infra$"`,
+			},
+		},
+		{
+			name: "invalid regex",
+			policies: `- name: invalid
+  match:
+    branches: "["
+  require:
+    timeout: true
+`,
+			expectError: true,
+		},
+		{
+			name: "unknown type",
+			policies: `- name: invalid
+  match:
+    types: [batch]
+  require:
+    timeout: true
+`,
+			expectError: true,
+		},
+		{
+			name:        "nothing required",
+			policies:    `- name: invalid`,
+			expectError: true,
+		},
+		{
+			name: "duplicate name",
+			policies: `- name: timeout
+  require:
+    timeout: true
+- name: timeout
+  require:
+    timeout: true
+`,
+			expectError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestFiles(t, dir, map[string]string{
+				".base.yaml": base + "policies:\n" + tt.policies,
+				"istio.yaml": jobs,
+			})
+			bc, err := ReadBase(nil, filepath.Join(dir, ".base.yaml"))
+			if tt.expectError {
+				if err == nil {
+					t.Fatalf("Test %q expected an error, but did not receive one", tt.name)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("Test %q did not expect an error, but received %v", tt.name, err)
+			}
+			cli := &Client{BaseConfig: bc, EnforcePolicies: true}
+			jobsConfig, err := cli.ReadJobsConfig(filepath.Join(dir, "istio.yaml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var violations []string
+			for _, branch := range jobsConfig.Branches {
+				_, err := cli.ConvertJobConfig(filepath.Join(dir, "istio.yaml"), jobsConfig, branch)
+				for _, ve := range ValidationErrors(err) {
+					violations = append(violations, ve.Message)
+				}
+			}
+			if diff := cmp.Diff(tt.violations, violations); diff != "" {
+				t.Fatalf("Violations do not match, (-want, +got): \n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNestedPolicies(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"parent.yaml": `policies:
+- name: timeout
+  require:
+    timeout: true
+- name: trusted
+  match:
+    clusters: [test-infra-trusted]
+  require:
+    repos: ^istio/test-infra$
+`,
+		"child.yaml": `policies:
+- name: timeout
+  match:
+    types: [periodic]
+  require:
+    timeout: true
+- name: labels
+  require:
+    labels: [preset-service-account]
+`,
+	})
+	parent, err := ReadBase(nil, filepath.Join(dir, "parent.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := ReadBase(&parent, filepath.Join(dir, "child.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range child.Policies {
+		names = append(names, p.Name)
+	}
+	// The policy redefined by the nested base config replaces the parent one.
+	if diff := cmp.Diff([]string{"trusted", "timeout", "labels"}, names); diff != "" {
+		t.Fatalf("Policies do not match, (-want, +got): \n%s", diff)
+	}
+	if got := child.Policies[1].Match.Types; len(got) != 1 || got[0] != TypePeriodic {
+		t.Fatalf("Expected the timeout policy of the nested base config, got types %v", got)
+	}
+}
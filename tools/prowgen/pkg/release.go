@@ -0,0 +1,270 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/test-infra/prow/config"
+
+	"istio.io/test-infra/tools/prowgen/pkg/spec"
+)
+
+// RetirePlan contains all the changes to retire a release branch that reached
+// its end of life.
+type RetirePlan struct {
+	// Release is the version of the release branch, e.g. 1.11 for
+	// release-1.11.
+	Release string
+	// OutputFiles returns the generated files that only contain the jobs of
+	// the org/repo:branch.
+	OutputFiles func(org, repo, branch string) []string
+
+	// RemovedFiles are the meta config files and the generated files to
+	// remove.
+	RemovedFiles []string
+	// UpdatedFiles are the meta config files that are kept for their other
+	// branches.
+	UpdatedFiles []BranchFile
+	// Dashboards are the TestGrid dashboards of the jobs of the release
+	// branch.
+	Dashboards []string
+}
+
+// AddFile adds the changes to the plan if the meta config file generates jobs
+// for the release branch.
+func (p *RetirePlan) AddFile(cli *Client, file string) error {
+	jobsConfig, err := cli.ReadJobsConfig(file)
+	if err != nil {
+		return err
+	}
+	branch := "release-" + p.Release
+	if !sets.NewString(jobsConfig.Branches...).Has(branch) {
+		return nil
+	}
+
+	output, err := cli.ConvertJobConfig(file, jobsConfig, branch)
+	if err != nil {
+		return err
+	}
+	dashboards := sets.NewString(p.Dashboards...)
+	for _, jb := range jobBases(output) {
+		for _, d := range strings.Split(jb.Annotations[TestGridDashboard], ",") {
+			if d = strings.TrimSpace(d); d != "" && !dashboards.Has(d) {
+				dashboards.Insert(d)
+				p.Dashboards = append(p.Dashboards, d)
+			}
+		}
+	}
+
+	if len(jobsConfig.Branches) == 1 {
+		p.RemovedFiles = append(p.RemovedFiles, file)
+	} else {
+		// The other fields are written back as they are, without the base
+		// config.
+		raw, err := ReadRawJobsConfig(file)
+		if err != nil {
+			return err
+		}
+		// Only the retired branch is removed, in the order of the file.
+		branches := make([]string, 0, len(raw.Branches))
+		for _, b := range raw.Branches {
+			if b != branch {
+				branches = append(branches, b)
+			}
+		}
+		raw.Branches = branches
+		delete(raw.BranchOverrides, branch)
+		p.UpdatedFiles = append(p.UpdatedFiles, BranchFile{Source: file, Path: file, Config: raw})
+	}
+	for _, generated := range p.OutputFiles(jobsConfig.Org, jobsConfig.Repo, branch) {
+		if _, err := os.Stat(generated); err == nil && !sets.NewString(p.RemovedFiles...).Has(generated) {
+			p.RemovedFiles = append(p.RemovedFiles, generated)
+		}
+	}
+	return nil
+}
+
+// String returns the human readable form of the plan.
+func (p *RetirePlan) String() string {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("Files to remove (%d):\n", len(p.RemovedFiles)))
+	for _, f := range p.RemovedFiles {
+		sb.WriteString(fmt.Sprintf("  %s\n", f))
+	}
+	sb.WriteString(fmt.Sprintf("Files to remove release-%s from (%d):\n", p.Release, len(p.UpdatedFiles)))
+	for _, f := range p.UpdatedFiles {
+		sb.WriteString(fmt.Sprintf("  %s\n", f.Path))
+	}
+	sb.WriteString(fmt.Sprintf("TestGrid dashboards to remove (%d):\n", len(p.Dashboards)))
+	for _, d := range p.Dashboards {
+		sb.WriteString(fmt.Sprintf("  %s\n", d))
+	}
+	return sb.String()
+}
+
+// Apply removes and updates the files, and removes the dashboards from the
+// TestGrid config file, if it's not empty.
+func (p *RetirePlan) Apply(testgridConfig string) error {
+	for _, f := range p.RemovedFiles {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing %s: %v", f, err)
+		}
+	}
+	for _, f := range p.UpdatedFiles {
+		if err := WriteJobsConfig(f.Config, f.Path); err != nil {
+			return fmt.Errorf("error writing %s: %v", f.Path, err)
+		}
+	}
+	if testgridConfig != "" {
+		return RemoveTestgridDashboards(testgridConfig, p.Dashboards)
+	}
+	return nil
+}
+
+// PromotePlan contains the changes to bump the images of a release branch to
+// a new tag.
+type PromotePlan struct {
+	// Release is the version of the release branch, e.g. 1.11 for
+	// release-1.11.
+	Release string
+	// Tag is the new tag of the images.
+	Tag string
+
+	Files []BranchFile
+	// Images are the images to replace, with the new images as the targets.
+	Images []ImageTag
+}
+
+// AddFile adds the meta config file to the plan if it's for the release branch
+// and has images tagged for the release branch, i.e. whose tag starts with
+// release-<version>. The images of the file, the jobs, the sidecars, the
+// architecture configs and the branch override of the release branch are all
+// bumped.
+func (p *PromotePlan) AddFile(file string) error {
+	// The file is written back as it is, so the base config must not be
+	// applied.
+	jobsConfig, err := ReadRawJobsConfig(file)
+	if err != nil {
+		return err
+	}
+	branch := "release-" + p.Release
+	if !sets.NewString(jobsConfig.Branches...).Has(branch) {
+		return nil
+	}
+
+	changed := p.promoteImages(&jobsConfig.CommonConfig, branch)
+	for i := range jobsConfig.Jobs {
+		changed = p.promoteImages(&jobsConfig.Jobs[i].CommonConfig, branch) || changed
+	}
+	if override, ok := jobsConfig.BranchOverrides[branch]; ok {
+		changed = p.promoteImages(&override.CommonConfig, branch) || changed
+		for i := range override.Jobs {
+			changed = p.promoteImages(&override.Jobs[i].CommonConfig, branch) || changed
+		}
+		jobsConfig.BranchOverrides[branch] = override
+	}
+	if changed {
+		p.Files = append(p.Files, BranchFile{Source: file, Path: file, Config: jobsConfig})
+	}
+	return nil
+}
+
+// promoteImages replaces the images of the common config tagged for the
+// branch, and returns whether any is replaced.
+func (p *PromotePlan) promoteImages(cc *spec.CommonConfig, branch string) bool {
+	changed := false
+	promote := func(image *string) {
+		i := strings.LastIndex(*image, ":")
+		if i == -1 || strings.Contains((*image)[i:], "/") {
+			return
+		}
+		// The tags of release-1.11 must not be promoted for release-1.1.
+		if tag := (*image)[i+1:]; tag != branch && !strings.HasPrefix(tag, branch+"-") {
+			return
+		}
+		newImage := (*image)[:i+1] + p.Tag
+		if newImage == *image {
+			return
+		}
+		tag := ImageTag{Source: *image, Target: newImage}
+		found := false
+		for _, t := range p.Images {
+			found = found || t == tag
+		}
+		if !found {
+			p.Images = append(p.Images, tag)
+		}
+		*image = newImage
+		changed = true
+	}
+
+	promote(&cc.Image)
+	for i := range cc.Sidecars {
+		promote(&cc.Sidecars[i].Image)
+	}
+	for _, arch := range sets.StringKeySet(cc.ArchitectureConfigs).List() {
+		ac := cc.ArchitectureConfigs[arch]
+		promote(&ac.Image)
+		cc.ArchitectureConfigs[arch] = ac
+	}
+	return changed
+}
+
+// String returns the human readable form of the plan.
+func (p *PromotePlan) String() string {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("Files to update (%d):\n", len(p.Files)))
+	for _, f := range p.Files {
+		sb.WriteString(fmt.Sprintf("  %s\n", f.Path))
+	}
+	sb.WriteString(fmt.Sprintf("Images to replace (%d):\n", len(p.Images)))
+	for _, t := range p.Images {
+		sb.WriteString(fmt.Sprintf("  %s -> %s\n", t.Source, t.Target))
+	}
+	return sb.String()
+}
+
+// Apply writes the updated meta config files.
+func (p *PromotePlan) Apply() error {
+	for _, f := range p.Files {
+		if err := WriteJobsConfig(f.Config, f.Path); err != nil {
+			return fmt.Errorf("error writing %s: %v", f.Path, err)
+		}
+	}
+	return nil
+}
+
+// jobBases returns the job bases of all the Prow jobs of the job config.
+func jobBases(jc config.JobConfig) []config.JobBase {
+	res := make([]config.JobBase, 0)
+	for _, jobs := range jc.PresubmitsStatic {
+		for _, job := range jobs {
+			res = append(res, job.JobBase)
+		}
+	}
+	for _, jobs := range jc.PostsubmitsStatic {
+		for _, job := range jobs {
+			res = append(res, job.JobBase)
+		}
+	}
+	for _, job := range jc.Periodics {
+		res = append(res, job.JobBase)
+	}
+	return res
+}
@@ -0,0 +1,258 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755); err != nil {
+			t.Fatalf("Failed to create the directory of %s: %v", name, err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func TestRetirePlan(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"istio-1.11.yaml": `org: istio
+repo: istio
+image: gcr.io/istio-testing/build-tools:release-1.11
+branches: [release-1.11]
+jobs:
+- name: unit
+  command: [make, test]
+`,
+		"tools.yaml": `org: istio
+repo: tools
+image: gcr.io/istio-testing/build-tools:master
+branches: [release-1.12, master, release-1.11]
+jobs:
+- name: lint
+  types: [presubmit]
+  command: [make, lint]
+`,
+		"api.yaml": `org: istio
+repo: api
+image: gcr.io/istio-testing/build-tools:master
+jobs:
+- name: build
+  command: [make, build]
+`,
+		"istio.istio.release-1.11.gen.yaml": "presubmits: {}\n",
+		"testgrid.yaml": `dashboards:
+- name: istio_api
+- name: istio_release-1.11_istio
+- name: istio_release-1.11_istio_postsubmit
+# Group all dashboards
+dashboard_groups:
+- name: istio
+  dashboard_names:
+  - istio_api
+  - istio_release-1.11_tools
+- name: istio_release-1.11
+  dashboard_names:
+  - istio_release-1.11_istio
+  - istio_release-1.11_istio_postsubmit
+`,
+	})
+
+	bc, err := ReadBase(nil, "testdata/.base.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read the base config: %v", err)
+	}
+	cli := &Client{BaseConfig: bc}
+	plan := &RetirePlan{Release: "1.11", OutputFiles: func(org, repo, branch string) []string {
+		return []string{filepath.Join(dir, org+"."+repo+"."+branch+".gen.yaml")}
+	}}
+	for _, file := range []string{"api.yaml", "istio-1.11.yaml", "tools.yaml"} {
+		if err := plan.AddFile(cli, filepath.Join(dir, file)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	wantRemoved := []string{filepath.Join(dir, "istio-1.11.yaml"), filepath.Join(dir, "istio.istio.release-1.11.gen.yaml")}
+	if diff := cmp.Diff(wantRemoved, plan.RemovedFiles); diff != "" {
+		t.Fatalf("Removed files do not match, (-want, +got): \n%s", diff)
+	}
+	wantDashboards := []string{"istio_release-1.11_istio", "istio_release-1.11_istio_postsubmit", "istio_release-1.11_tools"}
+	if diff := cmp.Diff(wantDashboards, plan.Dashboards); diff != "" {
+		t.Fatalf("Dashboards do not match, (-want, +got): \n%s", diff)
+	}
+
+	if err := plan.Apply(filepath.Join(dir, "testgrid.yaml")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, f := range wantRemoved {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Fatalf("Expected %s to be removed", f)
+		}
+	}
+	tools, err := ReadRawJobsConfig(filepath.Join(dir, "tools.yaml"))
+	if err != nil {
+		t.Fatalf("Failed to read the updated file: %v", err)
+	}
+	if diff := cmp.Diff([]string{"release-1.12", "master"}, tools.Branches); diff != "" {
+		t.Fatalf("Branches of the updated file do not match, (-want, +got): \n%s", diff)
+	}
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "testgrid.yaml"))
+	if err != nil {
+		t.Fatalf("Failed to read the TestGrid config: %v", err)
+	}
+	wantTestgrid := `dashboards:
+- name: istio_api
+# Group all dashboards
+dashboard_groups:
+- name: istio
+  dashboard_names:
+  - istio_api
+`
+	if diff := cmp.Diff(wantTestgrid, string(bs)); diff != "" {
+		t.Fatalf("TestGrid config does not match, (-want, +got): \n%s", diff)
+	}
+}
+
+func TestPromotePlan(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"istio-1.11.yaml": `branches:
+- release-1.11
+image: gcr.io/istio-testing/build-tools:release-1.11-2021-08-09T16-46-08
+jobs:
+- command:
+  - make
+  - test
+  name: unit
+- architecture_configs:
+    arm64:
+      image: gcr.io/istio-testing/build-tools-arm64:release-1.11-2021-08-09T16-46-08
+  command:
+  - make
+  - build
+  name: build
+  sidecars:
+  - image: docker:dind
+    name: dind
+org: istio
+repo: istio
+`,
+		"istio.yaml": `org: istio
+repo: istio
+image: gcr.io/istio-testing/build-tools:master-2021-08-09T16-46-08
+jobs:
+- name: unit
+  command: [make, test]
+`,
+	})
+
+	plan := &PromotePlan{Release: "1.11", Tag: "release-1.11-2021-09-01T00-00-00"}
+	for _, file := range []string{"istio-1.11.yaml", "istio.yaml"} {
+		if err := plan.AddFile(filepath.Join(dir, file)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	wantImages := []ImageTag{
+		{
+			Source: "gcr.io/istio-testing/build-tools:release-1.11-2021-08-09T16-46-08",
+			Target: "gcr.io/istio-testing/build-tools:release-1.11-2021-09-01T00-00-00",
+		},
+		{
+			Source: "gcr.io/istio-testing/build-tools-arm64:release-1.11-2021-08-09T16-46-08",
+			Target: "gcr.io/istio-testing/build-tools-arm64:release-1.11-2021-09-01T00-00-00",
+		},
+	}
+	if diff := cmp.Diff(wantImages, plan.Images); diff != "" {
+		t.Fatalf("Images do not match, (-want, +got): \n%s", diff)
+	}
+	if len(plan.Files) != 1 {
+		t.Fatalf("Expected 1 file to update, got %d", len(plan.Files))
+	}
+
+	if err := plan.Apply(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "istio-1.11.yaml"))
+	if err != nil {
+		t.Fatalf("Failed to read the updated file: %v", err)
+	}
+	want := `branches:
+- release-1.11
+image: gcr.io/istio-testing/build-tools:release-1.11-2021-09-01T00-00-00
+jobs:
+- command:
+  - make
+  - test
+  name: unit
+- architecture_configs:
+    arm64:
+      image: gcr.io/istio-testing/build-tools-arm64:release-1.11-2021-09-01T00-00-00
+  command:
+  - make
+  - build
+  name: build
+  sidecars:
+  - image: docker:dind
+    name: dind
+org: istio
+repo: istio
+`
+	if diff := cmp.Diff(want, string(bs)); diff != "" {
+		t.Fatalf("Updated file does not match, (-want, +got): \n%s", diff)
+	}
+}
+
+func TestPromotePlanBranchPrefix(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"istio-1.1.yaml": `org: istio
+repo: istio
+branches: [release-1.1]
+image: gcr.io/istio-testing/build-tools:release-1.1-2021-08-09T16-46-08
+jobs:
+- name: unit
+  command: [make, test]
+  sidecars:
+  - name: proxy
+    image: gcr.io/istio-testing/proxy:release-1.11-2021-08-09T16-46-08
+- name: build
+  command: [make, build]
+  image: gcr.io/istio-testing/build-tools:release-1.10-2021-08-09T16-46-08
+`,
+	})
+
+	plan := &PromotePlan{Release: "1.1", Tag: "release-1.1-2021-09-01T00-00-00"}
+	if err := plan.AddFile(filepath.Join(dir, "istio-1.1.yaml")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	wantImages := []ImageTag{
+		{
+			Source: "gcr.io/istio-testing/build-tools:release-1.1-2021-08-09T16-46-08",
+			Target: "gcr.io/istio-testing/build-tools:release-1.1-2021-09-01T00-00-00",
+		},
+	}
+	if diff := cmp.Diff(wantImages, plan.Images); diff != "" {
+		t.Fatalf("Images do not match, (-want, +got): \n%s", diff)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"gopkg.in/robfig/cron.v2"
+	"k8s.io/test-infra/prow/config"
+)
+
+// CronAuto is the cron of the periodic jobs scheduled at a time derived from
+// their names.
+const CronAuto = "auto"
+
+const minutesPerDay = 24 * 60
+
+// scheduleWindow is a daily window, in minutes since midnight in UTC. end is
+// exclusive, and is after the midnight of the next day if the window wraps.
+type scheduleWindow struct {
+	start int
+	end   int
+}
+
+// parseScheduleWindow parses a window such as 02:00-06:00, or 22:00-04:00 for a
+// window wrapping past midnight. An empty window is the whole day.
+func parseScheduleWindow(window string) (scheduleWindow, error) {
+	if window == "" {
+		return scheduleWindow{start: 0, end: minutesPerDay}, nil
+	}
+	parts := strings.Split(window, "-")
+	if len(parts) != 2 {
+		return scheduleWindow{}, fmt.Errorf("invalid schedule_window %q, must be in the form of HH:MM-HH:MM", window)
+	}
+	var minutes [2]int
+	for i, p := range parts {
+		var h, m int
+		if n, err := fmt.Sscanf(p, "%d:%d", &h, &m); err != nil || n != 2 || len(p) != 5 ||
+			h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m != 0) {
+			return scheduleWindow{}, fmt.Errorf("invalid time %q in schedule_window %q, must be in the form of HH:MM", p, window)
+		}
+		minutes[i] = h*60 + m
+	}
+	w := scheduleWindow{start: minutes[0] % minutesPerDay, end: minutes[1]}
+	// The window wraps past midnight, or is the whole day if the start and
+	// the end are the same.
+	if w.end <= w.start {
+		w.end += minutesPerDay
+	}
+	return w, nil
+}
+
+// autoCron returns the cron to run the periodic job daily at a time in the
+// window. The time is derived from the hash of the name of the Prow job, so
+// it's stable across the generations and different for each job.
+func autoCron(name, window string) (string, error) {
+	w, err := parseScheduleWindow(window)
+	if err != nil {
+		return "", err
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	minute := (w.start + int(h.Sum32()%uint32(w.end-w.start))) % minutesPerDay
+	return fmt.Sprintf("%d %d * * *", minute%60, minute/60), nil
+}
+
+// PeriodicLoad returns the average number of runs of the periodic jobs started
+// in each hour of the day in UTC, computed over a week so that the crons of
+// specific days of the week are accounted for. The start time of the jobs
+// scheduled by interval is not fixed, so their runs are spread evenly over the
+// day.
+func PeriodicLoad(periodics []config.Periodic) ([24]float64, error) {
+	var load [24]float64
+	// Any Monday works, since the crons are in UTC.
+	start := time.Date(2021, time.January, 4, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 7)
+	for _, p := range periodics {
+		switch {
+		case p.Cron != "":
+			schedule, err := cron.Parse(p.Cron)
+			if err != nil {
+				return load, fmt.Errorf("invalid cron %q of %s: %v", p.Cron, p.Name, err)
+			}
+			for t := schedule.Next(start.Add(-time.Second)); !t.IsZero() && t.Before(end); t = schedule.Next(t) {
+				load[t.Hour()] += 1.0 / 7
+			}
+		case p.Interval != "":
+			d, err := time.ParseDuration(p.Interval)
+			if err != nil || d <= 0 {
+				return load, fmt.Errorf("invalid interval %q of %s", p.Interval, p.Name)
+			}
+			for i := range load {
+				load[i] += float64(time.Hour) / float64(d)
+			}
+		}
+	}
+	return load, nil
+}
+
+// FormatPeriodicLoad returns the human readable form of the load, with a bar
+// for each hour.
+func FormatPeriodicLoad(load [24]float64) string {
+	const maxBar = 50
+	peak := 0.0
+	for _, l := range load {
+		if l > peak {
+			peak = l
+		}
+	}
+	sb := strings.Builder{}
+	sb.WriteString("Hour (UTC)    Runs\n")
+	for hour, l := range load {
+		bar := 0
+		if peak > 0 {
+			bar = int(l/peak*maxBar + 0.5)
+		}
+		sb.WriteString(fmt.Sprintf("%02d:00       %6.1f  %s\n", hour, l, strings.Repeat("#", bar)))
+	}
+	return sb.String()
+}
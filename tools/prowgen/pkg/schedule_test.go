@@ -0,0 +1,159 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"testing"
+
+	"k8s.io/test-infra/prow/config"
+
+	"istio.io/test-infra/tools/prowgen/pkg/spec"
+)
+
+func TestAutoCron(t *testing.T) {
+	tests := []struct {
+		window      string
+		hours       []int
+		expectError bool
+	}{
+		{window: "", hours: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23}},
+		{window: "02:00-04:00", hours: []int{2, 3}},
+		{window: "22:00-02:00", hours: []int{22, 23, 0, 1}},
+		{window: "00:00-24:00", hours: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23}},
+		{window: "2:00-04:00", expectError: true},
+		{window: "02:00", expectError: true},
+		{window: "02:00-25:00", expectError: true},
+		{window: "02:60-04:00", expectError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.window, func(t *testing.T) {
+			allowed := map[int]bool{}
+			for _, h := range tt.hours {
+				allowed[h] = true
+			}
+			for i := 0; i < 100; i++ {
+				name := fmt.Sprintf("job-%d_istio_periodic", i)
+				c, err := autoCron(name, tt.window)
+				if tt.expectError {
+					if err == nil {
+						t.Fatalf("Expected an error for window %q, but did not receive one", tt.window)
+					}
+					return
+				} else if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				var minute, hour int
+				if _, err := fmt.Sscanf(c, "%d %d * * *", &minute, &hour); err != nil {
+					t.Fatalf("Unexpected cron %q: %v", c, err)
+				}
+				if !allowed[hour] {
+					t.Fatalf("Cron %q of %s is not in the window %q", c, name, tt.window)
+				}
+				if again, _ := autoCron(name, tt.window); again != c {
+					t.Fatalf("Cron of %s is not stable: %q and %q", name, c, again)
+				}
+			}
+		})
+	}
+
+	// The copies of the job for the release branches are staggered as well.
+	master, _ := autoCron("nightly_istio_periodic", "")
+	release, _ := autoCron("nightly_istio_release-1.11_periodic", "")
+	if master == release {
+		t.Fatalf("Expected different crons for the release branch, got %q", master)
+	}
+}
+
+func TestScheduleWindowValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        spec.CommonConfig
+		common      spec.CommonConfig
+		expectError bool
+	}{
+		{
+			name:   "cron auto",
+			common: spec.CommonConfig{Cron: CronAuto, ScheduleWindow: "02:00-06:00"},
+		},
+		{
+			// The other jobs of the file still use the window.
+			name:   "cron overriding the cron auto of the file",
+			file:   spec.CommonConfig{Cron: CronAuto, ScheduleWindow: "02:00-06:00"},
+			common: spec.CommonConfig{Cron: "0 2 * * *"},
+		},
+		{
+			name:        "file without cron auto",
+			file:        spec.CommonConfig{ScheduleWindow: "02:00-06:00"},
+			common:      spec.CommonConfig{Cron: "0 2 * * *"},
+			expectError: true,
+		},
+		{
+			name:        "cron",
+			common:      spec.CommonConfig{Cron: "0 2 * * *", ScheduleWindow: "02:00-06:00"},
+			expectError: true,
+		},
+		{
+			name:        "interval",
+			common:      spec.CommonConfig{Interval: "24h", ScheduleWindow: "02:00-06:00"},
+			expectError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.file.Image = "gcr.io/istio-testing/build-tools:latest"
+			jobsConfig := spec.JobsConfig{
+				Org:          "istio",
+				Repo:         "istio",
+				CommonConfig: tt.file,
+				Jobs: []spec.Job{{
+					Name:         "nightly",
+					Types:        []string{TypePeriodic},
+					Command:      []string{"make", "test"},
+					CommonConfig: mergeCommonConfig(tt.file, tt.common),
+				}},
+			}
+			err := validateJobsConfig("test.yaml", jobsConfig)
+			if tt.expectError && err == nil {
+				t.Fatalf("Test %q expected an error, but did not receive one", tt.name)
+			} else if !tt.expectError && err != nil {
+				t.Fatalf("Test %q did not expect an error, but received %v", tt.name, err)
+			}
+		})
+	}
+}
+
+func TestPeriodicLoad(t *testing.T) {
+	load, err := PeriodicLoad([]config.Periodic{
+		{JobBase: config.JobBase{Name: "daily"}, Cron: "30 2 * * *"},
+		{JobBase: config.JobBase{Name: "twice-a-day"}, Cron: "0 2,14 * * *"},
+		{JobBase: config.JobBase{Name: "weekdays"}, Cron: "0 2 * * 1-5"},
+		{JobBase: config.JobBase{Name: "interval"}, Interval: "2h"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	const epsilon = 1e-9
+	want := map[int]float64{2: 2 + 5.0/7 + 0.5, 14: 1 + 0.5, 3: 0.5}
+	for hour, w := range want {
+		if d := load[hour] - w; d > epsilon || d < -epsilon {
+			t.Errorf("Load of hour %d: want %v, got %v", hour, w, load[hour])
+		}
+	}
+
+	if _, err := PeriodicLoad([]config.Periodic{{JobBase: config.JobBase{Name: "invalid"}, Cron: "invalid"}}); err == nil {
+		t.Fatal("Expected an error for an invalid cron, but did not receive one")
+	}
+}
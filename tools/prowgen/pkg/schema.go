@@ -0,0 +1,277 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/xeipuuv/gojsonschema"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	prowjob "k8s.io/test-infra/prow/apis/prowjobs/v1"
+	"sigs.k8s.io/yaml"
+
+	"istio.io/test-infra/tools/prowgen/pkg/decorator"
+	"istio.io/test-infra/tools/prowgen/pkg/spec"
+)
+
+const jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// JSONSchema is the subset of JSON Schema draft-07 used to describe the meta
+// config files.
+type JSONSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Ref                  string                 `json:"$ref,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 interface{}            `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	AllOf                []*JSONSchema          `json:"allOf,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	AdditionalProperties interface{}            `json:"additionalProperties,omitempty"`
+	Definitions          map[string]*JSONSchema `json:"definitions,omitempty"`
+}
+
+// schemaEnums returns the allowed values of the fields, keyed by "Type.Field"
+// of the spec types. The enum applies to the items for list fields.
+func schemaEnums() map[string][]string {
+	pullPolicies := []string{string(v1.PullAlways), string(v1.PullIfNotPresent), string(v1.PullNever)}
+	return map[string][]string{
+		"JobsConfig.HostType":          {HostTypeGitHub, HostTypeGerrit},
+		"Job.Types":                    {TypePresubmit, TypePostsubmit, TypePeriodic},
+		"CommonConfig.Modifiers":       decorator.Modifiers(),
+		"OutputLayout.Name":            OutputLayouts(),
+		"CommonConfig.ImagePullPolicy": pullPolicies,
+		"Sidecar.ImagePullPolicy":      pullPolicies,
+	}
+}
+
+// schemaTypes are the types that are (un)marshaled as JSON scalars instead of
+// objects.
+var schemaTypes = map[reflect.Type]JSONSchema{
+	reflect.TypeOf(resource.Quantity{}):  {Type: []string{"string", "number"}},
+	reflect.TypeOf(intstr.IntOrString{}): {Type: []string{"string", "integer"}},
+	reflect.TypeOf(metav1.Time{}):        {Type: "string", Format: "date-time"},
+	reflect.TypeOf(metav1.Duration{}):    {Type: "string"},
+	reflect.TypeOf(prowjob.Duration{}):   {Type: "string"},
+}
+
+// BaseConfigSchema returns the JSON Schema of the .base.yaml files.
+func BaseConfigSchema() *JSONSchema {
+	return newRootSchema("prowgen base config", reflect.TypeOf(spec.BaseConfig{}))
+}
+
+// JobsConfigSchema returns the JSON Schema of the meta job config files.
+func JobsConfigSchema() *JSONSchema {
+	return newRootSchema("prowgen meta job config", reflect.TypeOf(spec.JobsConfig{}))
+}
+
+func newRootSchema(title string, t reflect.Type) *JSONSchema {
+	g := &schemaGenerator{
+		descriptions: spec.Descriptions(),
+		enums:        schemaEnums(),
+		definitions:  map[string]*JSONSchema{},
+	}
+	root := g.schemaFor(t, "")
+	return &JSONSchema{
+		Schema:      jsonSchemaDraft,
+		Title:       title,
+		Ref:         root.Ref,
+		Definitions: g.definitions,
+	}
+}
+
+type schemaGenerator struct {
+	descriptions map[string]string
+	enums        map[string][]string
+	definitions  map[string]*JSONSchema
+}
+
+// schemaFor returns the schema of the type. Structs are added to the
+// definitions and referenced, and the enum of the field key is applied to
+// strings.
+func (g *schemaGenerator) schemaFor(t reflect.Type, key string) *JSONSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if s, ok := schemaTypes[t]; ok {
+		return &s
+	}
+	if t == reflect.TypeOf(spec.Matrix{}) {
+		return g.matrixSchema()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.String:
+		return &JSONSchema{Type: "string", Enum: g.enums[key]}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// []byte is marshaled as a base64 string.
+			return &JSONSchema{Type: "string"}
+		}
+		// Lists and maps can be null, which is unmarshaled as empty, and is
+		// written by the branch operation.
+		return &JSONSchema{Type: []string{"array", "null"}, Items: g.schemaFor(t.Elem(), key)}
+	case reflect.Map:
+		return &JSONSchema{Type: []string{"object", "null"}, AdditionalProperties: g.schemaFor(t.Elem(), "")}
+	case reflect.Struct:
+		name := definitionName(t)
+		if _, ok := g.definitions[name]; !ok {
+			def := &JSONSchema{
+				Type:                 "object",
+				Properties:           map[string]*JSONSchema{},
+				AdditionalProperties: false,
+			}
+			if isSpecType(t) {
+				def.Description = g.descriptions[t.Name()]
+			}
+			// Add the definition before its properties to support recursive
+			// types.
+			g.definitions[name] = def
+			g.addProperties(def, t)
+		}
+		return &JSONSchema{Ref: "#/definitions/" + name}
+	default:
+		return &JSONSchema{}
+	}
+}
+
+// matrixSchema returns the schema of the matrix, which is a map of the
+// dimensions with the reserved exclude and include keys.
+func (g *schemaGenerator) matrixSchema() *JSONSchema {
+	entries := func(desc string) *JSONSchema {
+		return &JSONSchema{
+			Description: desc,
+			Type:        "array",
+			Items:       &JSONSchema{Type: "object", AdditionalProperties: &JSONSchema{Type: "string"}},
+		}
+	}
+	return &JSONSchema{
+		Type: "object",
+		Properties: map[string]*JSONSchema{
+			"exclude": entries("exclude" + strings.TrimPrefix(g.descriptions["Matrix.Exclude"], "Exclude")),
+			"include": entries("include" + strings.TrimPrefix(g.descriptions["Matrix.Include"], "Include")),
+		},
+		AdditionalProperties: &JSONSchema{Type: "array", Items: &JSONSchema{Type: "string"}},
+	}
+}
+
+// addProperties adds the JSON fields of the struct to the schema, including
+// the fields of the inlined structs.
+func (g *schemaGenerator) addProperties(def *JSONSchema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			g.addProperties(def, ft)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		// The enums and descriptions are only defined for the spec types.
+		key := ""
+		if isSpecType(t) {
+			key = t.Name() + "." + f.Name
+		}
+		s := g.schemaFor(f.Type, key)
+		if desc, ok := g.descriptions[key]; ok {
+			// Refer to the field by its name in the config files.
+			if strings.HasPrefix(desc, f.Name+" ") {
+				desc = name + strings.TrimPrefix(desc, f.Name)
+			}
+			if s.Ref != "" {
+				// The siblings of $ref are ignored in draft-07.
+				s = &JSONSchema{AllOf: []*JSONSchema{s}}
+			}
+			s.Description = desc
+		}
+		def.Properties[name] = s
+	}
+}
+
+// definitionName returns the name of the definition of the struct, which is
+// its type name for the spec types, and the reverse domain notation used by
+// the Kubernetes OpenAPI definitions otherwise, e.g.
+// io.k8s.api.core.v1.EnvVar.
+func definitionName(t reflect.Type) string {
+	if isSpecType(t) {
+		return t.Name()
+	}
+	parts := strings.Split(t.PkgPath(), "/")
+	domain := strings.Split(parts[0], ".")
+	for i, j := 0, len(domain)-1; i < j; i, j = i+1, j-1 {
+		domain[i], domain[j] = domain[j], domain[i]
+	}
+	return strings.Join(append(append(domain, parts[1:]...), t.Name()), ".")
+}
+
+func isSpecType(t reflect.Type) bool {
+	return t.PkgPath() == reflect.TypeOf(spec.Job{}).PkgPath()
+}
+
+// ValidateSchema checks the YAML file against the JSON Schema in the schema
+// file, e.g. one of the schemas checked in for the Istio Prow jobs, and returns
+// a validation error for each violation.
+func ValidateSchema(schemaFile, file string) error {
+	schema, err := ioutil.ReadFile(schemaFile)
+	if err != nil {
+		return fmt.Errorf("error reading the schema %s: %v", schemaFile, err)
+	}
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", file, err)
+	}
+	doc, err := yaml.YAMLToJSON(bs)
+	if err != nil {
+		return &ValidationError{File: file, Message: err.Error()}
+	}
+	res, err := gojsonschema.Validate(gojsonschema.NewBytesLoader(schema), gojsonschema.NewBytesLoader(doc))
+	if err != nil {
+		return fmt.Errorf("error validating %s against %s: %v", file, schemaFile, err)
+	}
+	var errs error
+	for _, e := range res.Errors() {
+		errs = multierror.Append(errs, &ValidationError{File: file, Message: e.String()})
+	}
+	return errs
+}
@@ -0,0 +1,99 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xeipuuv/gojsonschema"
+	"sigs.k8s.io/yaml"
+)
+
+func validateAgainstSchema(t *testing.T, schema *JSONSchema, file string) []gojsonschema.ResultError {
+	t.Helper()
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := yaml.YAMLToJSON(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := gojsonschema.Validate(gojsonschema.NewGoLoader(schema), gojsonschema.NewBytesLoader(doc))
+	if err != nil {
+		t.Fatalf("Failed to validate %s: %v", file, err)
+	}
+	return res.Errors()
+}
+
+func TestSchema(t *testing.T) {
+	if errs := validateAgainstSchema(t, BaseConfigSchema(), "testdata/.base.yaml"); len(errs) != 0 {
+		t.Errorf("testdata/.base.yaml does not match the schema: %v", errs)
+	}
+
+	invalidFiles := []string{
+		"testdata/schema/unknown-field.yaml",
+		"testdata/schema/invalid-type.yaml",
+		"testdata/modifiers-invalid.yaml",
+	}
+	files, err := filepath.Glob("testdata/*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file, ".gen.yaml") || strings.HasPrefix(filepath.Base(file), ".") ||
+			file == "testdata/modifiers-invalid.yaml" {
+			continue
+		}
+		if errs := validateAgainstSchema(t, JobsConfigSchema(), file); len(errs) != 0 {
+			t.Errorf("%s does not match the schema: %v", file, errs)
+		}
+	}
+
+	for _, file := range invalidFiles {
+		if errs := validateAgainstSchema(t, JobsConfigSchema(), file); len(errs) == 0 {
+			t.Errorf("%s expected to not match the schema", file)
+		}
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	bs, err := json.Marshal(JobsConfigSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemaFile := filepath.Join(t.TempDir(), "jobs.schema.json")
+	if err := ioutil.WriteFile(schemaFile, bs, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ValidateSchema(schemaFile, "testdata/simple.yaml"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	file := "testdata/schema/unknown-field.yaml"
+	errs := ValidationErrors(ValidateSchema(schemaFile, file))
+	if len(errs) == 0 {
+		t.Fatalf("%s expected to not match the schema", file)
+	}
+	for _, e := range errs {
+		if e.File != file {
+			t.Errorf("Expected the error to be reported for %s, got %v", file, e)
+		}
+	}
+}
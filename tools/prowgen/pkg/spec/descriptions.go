@@ -0,0 +1,71 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spec
+
+import (
+	_ "embed"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+//go:embed spec.go
+var source string
+
+// Descriptions returns the doc comments of the types defined in spec.go and of
+// their fields, keyed by "Type" and "Type.Field" respectively. The comments are
+// joined into a single line.
+func Descriptions() map[string]string {
+	res := map[string]string{}
+	f, err := parser.ParseFile(token.NewFileSet(), "spec.go", source, parser.ParseComments)
+	if err != nil {
+		// spec.go is compiled into this package, so it always parses.
+		panic(err)
+	}
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, s := range gd.Specs {
+			ts := s.(*ast.TypeSpec)
+			doc := ts.Doc
+			if doc == nil && len(gd.Specs) == 1 {
+				doc = gd.Doc
+			}
+			if doc != nil {
+				res[ts.Name.Name] = oneLine(doc.Text())
+			}
+			st, ok := ts.Type.(*ast.StructType)
+			if !ok {
+				continue
+			}
+			for _, field := range st.Fields.List {
+				if field.Doc == nil {
+					continue
+				}
+				for _, name := range field.Names {
+					res[ts.Name.Name+"."+name.Name] = oneLine(field.Doc.Text())
+				}
+			}
+		}
+	}
+	return res
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -15,6 +15,8 @@
 package spec
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
 
 	v1 "k8s.io/api/core/v1"
@@ -27,13 +29,34 @@ import (
 type BaseConfig struct {
 	CommonConfig
 
+	// AutogenHeader is the header line added to each generated config file.
 	AutogenHeader string `json:"autogen_header,omitempty"`
 
+	// PathAliases is a map of org:alias. The repos of the orgs in this map are
+	// cloned with the alias as their path_alias.
 	PathAliases map[string]string `json:"path_aliases,omitempty"`
 
+	// ClusterOverrides is a map of architecture:cluster to schedule the jobs
+	// built for the architecture.
 	ClusterOverrides map[string]string `json:"cluster_overrides,omitempty"`
 
 	TestgridConfig TestgridConfig `json:"testgrid_config,omitempty"`
+
+	// OutputLayout configures the files the generated Prow jobs are written
+	// to. It's only read from the .base.yaml file of the input directory.
+	OutputLayout OutputLayout `json:"output_layout,omitempty"`
+
+	// Imports are the preset library files to add the presets of, relative to
+	// the folder of the .base.yaml file. The presets defined in the .base.yaml
+	// file override the imported ones, and it's an error if multiple libraries
+	// define a preset differently. Once the base config is read, they are the
+	// paths of the files imported by the .base.yaml file, relative to the
+	// current directory.
+	Imports []string `json:"imports,omitempty"`
+
+	// Policies are the rules the generated Prow jobs must follow. The policies
+	// of a nested .base.yaml file are added to the ones of its parents.
+	Policies []Policy `json:"policies,omitempty"`
 }
 
 func (baseConfig *BaseConfig) DeepCopy() BaseConfig {
@@ -45,10 +68,90 @@ func (baseConfig *BaseConfig) DeepCopy() BaseConfig {
 	return newBaseConfig
 }
 
+// PresetLibrary represents a file imported by the base configs, to share the
+// presets between multiple folders.
+type PresetLibrary struct {
+	// ResourcePresets is a map of preset resource allocations.
+	ResourcePresets map[string]v1.ResourceRequirements `json:"resources_presets,omitempty"`
+	// RequirementPresets is a map of dependency presets.
+	RequirementPresets map[string]RequirementPreset `json:"requirement_presets,omitempty"`
+}
+
+// Policy is a rule checked on the generated Prow jobs, e.g. that the jobs with
+// a requirement are scheduled in a trusted cluster.
+type Policy struct {
+	// Name identifies the policy in the violations.
+	Name string `json:"name"`
+	// Description is reported with the violations of the policy.
+	Description string `json:"description,omitempty"`
+	// Match selects the Prow jobs the policy applies to. It applies to all the
+	// jobs if it's empty.
+	Match PolicyMatch `json:"match,omitempty"`
+	// Require is what the selected Prow jobs must satisfy.
+	Require PolicyRequire `json:"require"`
+}
+
+// PolicyMatch selects the Prow jobs a policy applies to. A job is selected if
+// it matches all the fields that are set.
+type PolicyMatch struct {
+	// Types are the types of the jobs, i.e. presubmit, postsubmit or periodic.
+	Types []string `json:"types,omitempty"`
+	// Repos is a regex of the org/repo of the jobs.
+	Repos string `json:"repos,omitempty"`
+	// Branches is a regex of the branch of the jobs.
+	Branches string `json:"branches,omitempty"`
+	// Names is a regex of the names of the Prow jobs.
+	Names string `json:"names,omitempty"`
+	// Requirements selects the jobs applying any of the requirement presets.
+	Requirements []string `json:"requirements,omitempty"`
+	// Clusters selects the jobs scheduled in any of the clusters.
+	Clusters []string `json:"clusters,omitempty"`
+}
+
+// PolicyRequire is what the Prow jobs selected by a policy must satisfy. All
+// the fields that are set are checked.
+type PolicyRequire struct {
+	// Timeout requires the jobs to set a timeout.
+	Timeout bool `json:"timeout,omitempty"`
+	// Images is a regex that the images of all the containers must match, e.g.
+	// to pin them to a dated tag.
+	Images string `json:"images,omitempty"`
+	// Clusters are the clusters the jobs can be scheduled in.
+	Clusters []string `json:"clusters,omitempty"`
+	// ExcludedClusters are the clusters the jobs cannot be scheduled in.
+	ExcludedClusters []string `json:"excluded_clusters,omitempty"`
+	// Repos is a regex that the org/repo of the jobs must match.
+	Repos string `json:"repos,omitempty"`
+	// Labels are the labels the jobs must set.
+	Labels []string `json:"labels,omitempty"`
+}
+
+// TestgridConfig configures the TestGrid annotations of the jobs.
 type TestgridConfig struct {
-	Enabled            bool   `json:"enabled,omitempty"`
-	AlertEmail         string `json:"alert_email,omitempty"`
+	// Enabled adds the TestGrid annotations to all the jobs.
+	Enabled bool `json:"enabled,omitempty"`
+	// AlertEmail is the email address to send the TestGrid alerts to.
+	AlertEmail string `json:"alert_email,omitempty"`
+	// NumFailuresToAlert is only set for the postsubmit and periodic jobs.
 	NumFailuresToAlert string `json:"num_failures_to_alert,omitempty"`
+	// GCSLogBucket is the bucket used to derive the TestGrid gcs_prefix for the
+	// jobs that do not set gcs_log_bucket, i.e. the default bucket of Prow.
+	GCSLogBucket string `json:"gcs_log_bucket,omitempty"`
+}
+
+// OutputLayout configures the files the generated Prow jobs are written to,
+// relative to the output directory.
+type OutputLayout struct {
+	// Name is the name of a registered layout. The built-in layouts are branch,
+	// which writes a file per org/repo:branch, repo, which writes a file per
+	// org/repo, single, which writes all the jobs to one file, and type, which
+	// writes a file per org/repo:branch and job type. Defaults to branch.
+	Name string `json:"name,omitempty"`
+	// Template is a Go template of the path of the files, with the .Org,
+	// .Repo, .Branch and .Type fields, e.g. {{.Org}}/{{.Repo}}.{{.Type}}.yaml.
+	// The jobs are written to different files for each type if the path
+	// depends on .Type. It cannot be set together with name.
+	Template string `json:"template,omitempty"`
 }
 
 // JobsConfig represents the fields that can be defined in a meta job file, and
@@ -56,59 +159,161 @@ type TestgridConfig struct {
 type JobsConfig struct {
 	CommonConfig
 
+	// SupportReleaseBranching marks that the file can be cloned to create the
+	// meta config file of a new release branch.
 	SupportReleaseBranching bool `json:"support_release_branching,omitempty"`
 
-	Repo     string   `json:"repo,omitempty"`
-	Org      string   `json:"org,omitempty"`
-	CloneURI string   `json:"clone_uri,omitempty"`
+	// HostType is the code review host of the repo, either github or gerrit.
+	// When it's set, the clone URI, reporter labels and triggers of the jobs
+	// are set consistently for the host, and the fields that are not supported
+	// by the host are rejected.
+	HostType string `json:"host_type,omitempty"`
+	Repo     string `json:"repo,omitempty"`
+	Org      string `json:"org,omitempty"`
+	// CloneURI overrides the URI to clone the repo from.
+	CloneURI string `json:"clone_uri,omitempty"`
+	// Branches are the branches to generate the jobs for. Defaults to master.
 	Branches []string `json:"branches,omitempty"`
+	// BranchOverrides is a map of branch:override that changes the jobs
+	// generated for the branch, so that the branches with small differences
+	// can share a file.
+	BranchOverrides map[string]BranchOverride `json:"branch_overrides,omitempty"`
 
 	Jobs []Job `json:"jobs,omitempty"`
 }
 
+// BranchOverride changes the jobs generated for a branch of a meta config file.
+type BranchOverride struct {
+	// CommonConfig is merged on top of the config of the file and of each job,
+	// i.e. it overrides the values set by the jobs.
+	CommonConfig
+
+	// Jobs are merged on top of the jobs with the same names, the same way as
+	// the jobs extending another job.
+	Jobs []Job `json:"jobs,omitempty"`
+	// ExcludedJobs are the names of the jobs not generated for the branch.
+	ExcludedJobs []string `json:"excluded_jobs,omitempty"`
+}
+
 // Job is the last layer for defining the actual Prow jobs.
 type Job struct {
 	CommonConfig
 
+	// DisableReleaseBranching excludes the job when the meta config file is
+	// cloned for a new release branch.
 	DisableReleaseBranching bool `json:"disable_release_branching,omitempty"`
 
-	Name    string   `json:"name,omitempty"`
+	Name string `json:"name,omitempty"`
+	// Extends is the name of another job in the same file that this job
+	// inherits from. The common config is merged the same way as the other
+	// layers, and the other fields are only inherited if not set in this job.
+	Extends string   `json:"extends,omitempty"`
 	Command []string `json:"command,omitempty"`
 	Args    []string `json:"args,omitempty"`
-	Tags    []string `json:"tags,omitempty"`
-	Types   []string `json:"types,omitempty"`
-	Repos   []string `json:"repos,omitempty"`
-	// Architectures defines architectures to build as. Defaults to amd64.
+	// Tags are only set for the periodic jobs.
+	Tags []string `json:"tags,omitempty"`
+	// Types are the types of Prow jobs to generate. Defaults to presubmit and
+	// postsubmit.
+	Types []string `json:"types,omitempty"`
+	// Repos are the extra repos to clone, in the form of org/repo.
+	Repos []string `json:"repos,omitempty"`
+	// Architectures defines architectures to build as, which are amd64, arm64
+	// and the ones configured in architecture_configs. Defaults to amd64.
 	Architectures []string `json:"architectures,omitempty"`
-
-	GerritPresubmitLabel  string `json:"gerrit_presubmit_label,omitempty"`
-	GerritPostsubmitLabel string `json:"gerrit_postsubmit_label,omitempty"`
+	// Needs are the names of the jobs in the same file that must succeed before
+	// the job is triggered. Only presubmits and postsubmits can have needs,
+	// and they must not set regex, run_if_changed or skip_if_only_changed,
+	// since Prow does not trigger them itself. The jobs they need must be
+	// generated for all the types of the job, and must not form a cycle. The Prow jobs are annotated with the Prow jobs of the
+	// same type they need and are needed by, for a downstream trigger to run
+	// them in order.
+	Needs []string `json:"needs,omitempty"`
+	// RunIfChanged are the names of the path sets that the presubmit and
+	// postsubmit jobs are run for, i.e. they are only run if any changed file
+	// matches the paths. It cannot be set together with regex.
+	RunIfChanged []string `json:"run_if_changed,omitempty"`
+	// SkipIfOnlyChanged are the names of the path sets that the presubmit and
+	// postsubmit jobs are skipped for, i.e. they are not run if all the changed
+	// files match the paths. It cannot be set together with regex or
+	// run_if_changed.
+	SkipIfOnlyChanged []string `json:"skip_if_only_changed,omitempty"`
 
 	ReporterConfig *prowjob.ReporterConfig `json:"reporter_config,omitempty"`
 }
 
+func (job *Job) DeepCopy() Job {
+	j, _ := yaml.Marshal(job)
+	newJob := Job{}
+	if err := yaml.Unmarshal(j, &newJob); err != nil {
+		log.Fatalf("Failed to unmarshal Job: %v", err)
+	}
+	return newJob
+}
+
 // CommonConfig contains all the common fields that can be overlayed through
 // BaseConfig->JobsConfig->Job
 type CommonConfig struct {
+	// GCSLogBucket is the GCS bucket to upload the logs and artifacts to.
 	GCSLogBucket                  string `json:"gcs_log_bucket,omitempty"`
 	TerminationGracePeriodSeconds int64  `json:"termination_grace_period_seconds,omitempty"`
 
+	// Interval is the interval to schedule the periodic jobs. It cannot be set
+	// together with cron.
 	Interval string `json:"interval,omitempty"`
-	Cron     string `json:"cron,omitempty"`
-
-	Cluster      string            `json:"cluster,omitempty"`
+	// Cron is the cron expression to schedule the periodic jobs, or auto to
+	// run them daily at a time derived from the hash of the Prow job name
+	// within schedule_window, so that the jobs, including the copies for the
+	// release branches, do not all start at the same time.
+	Cron string `json:"cron,omitempty"`
+	// ScheduleWindow is the daily window in UTC, e.g. 02:00-06:00, that cron
+	// auto schedules the periodic jobs in. It can wrap past midnight, e.g.
+	// 22:00-04:00, and defaults to the whole day. It can only be set together
+	// with cron auto.
+	ScheduleWindow string `json:"schedule_window,omitempty"`
+
+	// Cluster is the cluster to schedule the Prow job pods in.
+	Cluster string `json:"cluster,omitempty"`
+	// NodeSelector is not merged but overridden as a whole by each layer.
 	NodeSelector map[string]string `json:"node_selector,omitempty"`
+	// Tolerations, Affinity, HostAliases and DNSConfig configure the pods of
+	// the jobs. Like NodeSelector, each of them is not merged but overridden
+	// as a whole by each layer.
+	Tolerations []v1.Toleration  `json:"tolerations,omitempty"`
+	Affinity    *v1.Affinity     `json:"affinity,omitempty"`
+	HostAliases []v1.HostAlias   `json:"host_aliases,omitempty"`
+	DNSConfig   *v1.PodDNSConfig `json:"dns_config,omitempty"`
+	// PriorityClassName is the priority class of the pods of the jobs.
+	PriorityClassName string `json:"priority_class_name,omitempty"`
+	// RuntimeClassName is the runtime class of the pods of the jobs, e.g. to
+	// run them in a sandbox.
+	RuntimeClassName string `json:"runtime_class_name,omitempty"`
+	// ArchitectureConfigs is a map of architecture:config that customizes the
+	// jobs generated for each architecture. The architectures other than
+	// amd64 and arm64 can only be used once they are configured here. The
+	// config of an architecture is overridden as a whole by each layer.
+	ArchitectureConfigs map[string]ArchitectureConfig `json:"architecture_configs,omitempty"`
 
 	Annotations map[string]string `json:"annotations,omitempty"`
 	Labels      map[string]string `json:"labels,omitempty"`
 
-	Matrix map[string][]string `json:"matrix,omitempty"`
-	Params map[string]string   `json:"params,omitempty"`
-
-	ResourcePresets      map[string]v1.ResourceRequirements `json:"resources_presets,omitempty"`
-	RequirementPresets   map[string]RequirementPreset       `json:"requirement_presets,omitempty"`
-	Requirements         []string                           `json:"requirements,omitempty"`
-	ExcludedRequirements []string                           `json:"excluded_requirements,omitempty"`
+	// Matrix is a map of dimension:values. The jobs referencing
+	// $(matrix.dimension) are generated for each combination of the values.
+	Matrix *Matrix `json:"matrix,omitempty"`
+	// Params is a map of name:value that replaces $(params.name) in the jobs.
+	Params map[string]string `json:"params,omitempty"`
+
+	// ResourcePresets is a map of preset resource allocations that can be
+	// referenced by resources. The preset named default is used if resources
+	// is not set.
+	ResourcePresets map[string]v1.ResourceRequirements `json:"resources_presets,omitempty"`
+	// RequirementPresets is a map of dependency presets that can be
+	// referenced by requirements.
+	RequirementPresets map[string]RequirementPreset `json:"requirement_presets,omitempty"`
+	// Requirements are the names of the requirement presets of the jobs.
+	Requirements []string `json:"requirements,omitempty"`
+	// ExcludedRequirements are the names of the requirement presets that are
+	// removed from the requirements.
+	ExcludedRequirements []string `json:"excluded_requirements,omitempty"`
 
 	Env                []v1.EnvVar `json:"env,omitempty"`
 	Image              string      `json:"image,omitempty"`
@@ -116,14 +321,38 @@ type CommonConfig struct {
 	ImagePullSecrets   []string    `json:"image_pull_secrets,omitempty"`
 	ServiceAccountName string      `json:"service_account_name,omitempty"`
 
-	Regex   string `json:"regex,omitempty"`
+	// Regex is the run_if_changed regex of the presubmit and postsubmit jobs.
+	Regex string `json:"regex,omitempty"`
+	// Paths is a map of name:regexes of the path sets that the jobs can
+	// reference in run_if_changed and skip_if_only_changed. The regexes of a
+	// path set defined in multiple layers are merged.
+	Paths map[string][]string `json:"paths,omitempty"`
+	// Trigger is the regex of the GitHub comments that trigger the presubmit
+	// jobs. Only supported for GitHub repos.
 	Trigger string `json:"trigger,omitempty"`
 
+	// GerritPresubmitLabel is the label the presubmit jobs report to for
+	// Gerrit repos. Defaults to Code-Review.
+	GerritPresubmitLabel string `json:"gerrit_presubmit_label,omitempty"`
+	// GerritPostsubmitLabel is the label the postsubmit jobs report to for
+	// Gerrit repos. Defaults to Code-Review.
+	GerritPostsubmitLabel string `json:"gerrit_postsubmit_label,omitempty"`
+
+	// Timeout is how long the job is kept running before being aborted.
 	Timeout        *prowjob.Duration `json:"timeout,omitempty"`
 	MaxConcurrency int               `json:"max_concurrency,omitempty"`
 
-	Resources string   `json:"resources,omitempty"`
+	// Resources is the name of the resource preset of the main container.
+	Resources string `json:"resources,omitempty"`
+	// Modifiers change various parts of the generated jobs.
 	Modifiers []string `json:"modifiers,omitempty"`
+
+	Sidecars []Sidecar `json:"sidecars,omitempty"`
+
+	// SecurityContext is the security context of the main test container. It
+	// is not merged but overridden as a whole by each layer, and defaults to a
+	// privileged container if unset.
+	SecurityContext *v1.SecurityContext `json:"security_context,omitempty"`
 }
 
 func (commonConfig *CommonConfig) DeepCopy() CommonConfig {
@@ -135,8 +364,117 @@ func (commonConfig *CommonConfig) DeepCopy() CommonConfig {
 	return newCommonConfig
 }
 
+// Matrix is a map of dimension:values, with the reserved exclude and include
+// keys to remove and add combinations the same way as GitHub Actions.
+type Matrix struct {
+	Dimensions map[string][]string
+	// Exclude removes the combinations that match all the dimension:value pairs
+	// of an entry. An entry only applies to the jobs that reference all its
+	// dimensions.
+	Exclude []map[string]string
+	// Include adds the combination of an entry, which can have values that are
+	// not listed in the dimensions. An entry only applies to the jobs whose
+	// referenced dimensions are all set by it.
+	Include []map[string]string
+}
+
+const (
+	matrixExclude = "exclude"
+	matrixInclude = "include"
+)
+
+func (m Matrix) MarshalJSON() ([]byte, error) {
+	res := map[string]interface{}{}
+	for dimension, values := range m.Dimensions {
+		res[dimension] = values
+	}
+	if len(m.Exclude) != 0 {
+		res[matrixExclude] = m.Exclude
+	}
+	if len(m.Include) != 0 {
+		res[matrixInclude] = m.Include
+	}
+	return json.Marshal(res)
+}
+
+func (m *Matrix) UnmarshalJSON(bs []byte) error {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(bs, &raw); err != nil {
+		return err
+	}
+	*m = Matrix{}
+	for key, val := range raw {
+		var err error
+		switch key {
+		case matrixExclude:
+			err = json.Unmarshal(val, &m.Exclude)
+		case matrixInclude:
+			err = json.Unmarshal(val, &m.Include)
+		default:
+			values := []string{}
+			err = json.Unmarshal(val, &values)
+			if m.Dimensions == nil {
+				m.Dimensions = map[string][]string{}
+			}
+			m.Dimensions[key] = values
+		}
+		if err != nil {
+			return fmt.Errorf("invalid matrix %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// ArchitectureConfig overrides the config of the jobs generated for an
+// architecture.
+type ArchitectureConfig struct {
+	// Image replaces the image of the main container.
+	Image string `json:"image,omitempty"`
+	// Resources replaces the name of the resource preset of the main
+	// container.
+	Resources string `json:"resources,omitempty"`
+	// Cluster replaces the cluster to schedule the Prow job pods in, and takes
+	// precedence over the cluster_overrides of the base config.
+	Cluster string `json:"cluster,omitempty"`
+	// Requirements are the names of the requirement presets added to the jobs.
+	Requirements []string `json:"requirements,omitempty"`
+	// ExcludedRequirements are the names of the requirement presets removed
+	// from the jobs, e.g. kind for the architectures it does not support.
+	ExcludedRequirements []string `json:"excluded_requirements,omitempty"`
+	// Tolerations replace the tolerations of the jobs, e.g. to tolerate the
+	// taints of the node pool of the architecture.
+	Tolerations []v1.Toleration `json:"tolerations,omitempty"`
+	// Affinity replaces the affinity of the jobs.
+	Affinity *v1.Affinity `json:"affinity,omitempty"`
+}
+
+// Sidecar is an additional container that runs alongside the main test
+// container of a job, e.g. a registry mirror or a docker-in-docker daemon.
+type Sidecar struct {
+	Name            string      `json:"name,omitempty"`
+	Image           string      `json:"image,omitempty"`
+	ImagePullPolicy string      `json:"image_pull_policy,omitempty"`
+	Command         []string    `json:"command,omitempty"`
+	Args            []string    `json:"args,omitempty"`
+	Env             []v1.EnvVar `json:"env,omitempty"`
+	// Resources is the name of the resource preset for the sidecar. Unlike the
+	// main container, the default preset is not applied if it's empty.
+	Resources    string           `json:"resources,omitempty"`
+	VolumeMounts []v1.VolumeMount `json:"volumeMounts,omitempty"`
+	// SecurityContext defaults to a privileged container if unset.
+	SecurityContext *v1.SecurityContext `json:"security_context,omitempty"`
+}
+
 // RequirementPreset can be used to re-use settings across multiple jobs.
 type RequirementPreset struct {
+	// Containers are the names of the containers that the env, args and volume
+	// mounts are added to. Defaults to the main test container, which can be
+	// referenced as "test".
+	Containers []string `json:"containers,omitempty"`
+	// Privileged marks that the containers using this requirement must be
+	// privileged, e.g. to run docker or kind.
+	Privileged bool `json:"privileged,omitempty"`
+
 	Annotations  map[string]string `json:"annotations,omitempty"`
 	Labels       map[string]string `json:"labels,omitempty"`
 	Env          []v1.EnvVar       `json:"env,omitempty"`
@@ -0,0 +1,97 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/google"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+const (
+	TaggerRegistry = "registry"
+	TaggerDryRun   = "dry-run"
+	TaggerNoop     = "noop"
+)
+
+// ImageTagger adds a new tag to an existing image.
+type ImageTagger interface {
+	AddTag(source, target string) error
+}
+
+// NewImageTagger returns the image tagger of the kind, which is one of
+// registry, dry-run and noop.
+func NewImageTagger(kind string) (ImageTagger, error) {
+	switch kind {
+	case TaggerRegistry:
+		return &RegistryTagger{}, nil
+	case TaggerDryRun:
+		return &DryRunTagger{}, nil
+	case TaggerNoop:
+		return NoopTagger{}, nil
+	default:
+		return nil, fmt.Errorf("unknown image tagger %q, must be one of %s, %s, %s", kind, TaggerRegistry, TaggerDryRun, TaggerNoop)
+	}
+}
+
+// RegistryTagger adds the tags through the registry API, with the credentials
+// of the docker config or the Google application default credentials.
+type RegistryTagger struct {
+	Options []remote.Option
+}
+
+func (t *RegistryTagger) AddTag(source, target string) error {
+	src, err := name.ParseReference(source)
+	if err != nil {
+		return fmt.Errorf("invalid image %q: %v", source, err)
+	}
+	dst, err := name.NewTag(target)
+	if err != nil {
+		return fmt.Errorf("invalid image tag %q: %v", target, err)
+	}
+	opts := t.Options
+	if len(opts) == 0 {
+		opts = []remote.Option{remote.WithAuthFromKeychain(authn.NewMultiKeychain(authn.DefaultKeychain, google.Keychain))}
+	}
+	desc, err := remote.Get(src, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to get image %q: %v", source, err)
+	}
+	return remote.Tag(dst, desc, opts...)
+}
+
+// DryRunTagger only records the tags it's asked to add.
+type DryRunTagger struct {
+	mu   sync.Mutex
+	Tags []ImageTag
+}
+
+func (t *DryRunTagger) AddTag(source, target string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Tags = append(t.Tags, ImageTag{Source: source, Target: target})
+	return nil
+}
+
+// NoopTagger does not add any tag, e.g. when the tags are added separately.
+type NoopTagger struct{}
+
+func (NoopTagger) AddTag(string, string) error {
+	return nil
+}
@@ -0,0 +1,255 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	yamlv3 "gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/test-infra/prow/config"
+)
+
+// TestGridTabName can be set on a job to override the name of its dashboard
+// tab, which defaults to the job name.
+const TestGridTabName = "testgrid-tab-name"
+
+// TestgridOutput is the TestGrid configuration generated for the Prow jobs, in
+// the same YAML format as the TestGrid configuration files.
+type TestgridOutput struct {
+	Dashboards []TestgridDashboard `json:"dashboards,omitempty"`
+	TestGroups []TestgridTestGroup `json:"test_groups,omitempty"`
+}
+
+type TestgridDashboard struct {
+	Name         string                 `json:"name"`
+	DashboardTab []TestgridDashboardTab `json:"dashboard_tab,omitempty"`
+}
+
+type TestgridDashboardTab struct {
+	Name          string                `json:"name"`
+	TestGroupName string                `json:"test_group_name"`
+	AlertOptions  *TestgridAlertOptions `json:"alert_options,omitempty"`
+}
+
+type TestgridAlertOptions struct {
+	AlertMailToAddresses string `json:"alert_mail_to_addresses,omitempty"`
+}
+
+type TestgridTestGroup struct {
+	Name               string `json:"name"`
+	GCSPrefix          string `json:"gcs_prefix"`
+	NumFailuresToAlert int    `json:"num_failures_to_alert,omitempty"`
+}
+
+// GenerateTestgridConfig creates a dashboard tab and a test group for each of
+// the Prow jobs annotated with the testgrid-dashboards annotation. The GCS
+// prefix of the test group is derived from the GCS bucket of the job, or the
+// defaultBucket if the job does not have one configured. Test groups are named
+// after the jobs, so jobs with the same name are reported as an error.
+func GenerateTestgridConfig(jobConfigs []config.JobConfig, defaultBucket string) (TestgridOutput, error) {
+	dashboards := map[string][]TestgridDashboardTab{}
+	testGroups := map[string]TestgridTestGroup{}
+
+	var err error
+	add := func(jb config.JobBase, logsPath string) {
+		if _, ok := jb.Annotations[TestGridDashboard]; !ok {
+			return
+		}
+		bucket := defaultBucket
+		if jb.DecorationConfig != nil && jb.DecorationConfig.GCSConfiguration != nil &&
+			jb.DecorationConfig.GCSConfiguration.Bucket != "" {
+			bucket = jb.DecorationConfig.GCSConfiguration.Bucket
+		}
+		if bucket == "" {
+			err = multierror.Append(err, fmt.Errorf("job %s: cannot derive the TestGrid gcs_prefix, "+
+				"gcs_log_bucket is not set for the job and testgrid_config.gcs_log_bucket is empty", jb.Name))
+			return
+		}
+		bucket = strings.TrimSuffix(strings.TrimPrefix(bucket, "gs://"), "/")
+
+		tg := TestgridTestGroup{
+			Name:      jb.Name,
+			GCSPrefix: fmt.Sprintf("%s/%s/%s", bucket, logsPath, jb.Name),
+		}
+		if n, ok := jb.Annotations[TestGridNumFailures]; ok && n != "" {
+			num, e := strconv.Atoi(n)
+			if e != nil {
+				err = multierror.Append(err, fmt.Errorf("job %s: invalid %s annotation %q: %v", jb.Name, TestGridNumFailures, n, e))
+				return
+			}
+			tg.NumFailuresToAlert = num
+		}
+		if existing, ok := testGroups[jb.Name]; ok {
+			err = multierror.Append(err, fmt.Errorf("job %s: duplicate TestGrid test group name, "+
+				"gcs_prefix %s conflicts with %s", jb.Name, tg.GCSPrefix, existing.GCSPrefix))
+			return
+		}
+		testGroups[jb.Name] = tg
+
+		tab := TestgridDashboardTab{
+			Name:          jb.Name,
+			TestGroupName: jb.Name,
+		}
+		if name, ok := jb.Annotations[TestGridTabName]; ok && name != "" {
+			tab.Name = name
+		}
+		if email, ok := jb.Annotations[TestGridAlertEmail]; ok && email != "" {
+			tab.AlertOptions = &TestgridAlertOptions{AlertMailToAddresses: email}
+		}
+		for _, d := range strings.Split(jb.Annotations[TestGridDashboard], ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				dashboards[d] = append(dashboards[d], tab)
+			}
+		}
+	}
+
+	for _, jc := range jobConfigs {
+		for _, jobs := range jc.PresubmitsStatic {
+			for _, job := range jobs {
+				add(job.JobBase, "pr-logs/directory")
+			}
+		}
+		for _, jobs := range jc.PostsubmitsStatic {
+			for _, job := range jobs {
+				add(job.JobBase, "logs")
+			}
+		}
+		for _, job := range jc.Periodics {
+			add(job.JobBase, "logs")
+		}
+	}
+
+	output := TestgridOutput{}
+	for name, tabs := range dashboards {
+		sort.Slice(tabs, func(i, j int) bool {
+			return tabs[i].Name < tabs[j].Name
+		})
+		output.Dashboards = append(output.Dashboards, TestgridDashboard{Name: name, DashboardTab: tabs})
+	}
+	sort.Slice(output.Dashboards, func(i, j int) bool {
+		return output.Dashboards[i].Name < output.Dashboards[j].Name
+	})
+	for _, tg := range testGroups {
+		output.TestGroups = append(output.TestGroups, tg)
+	}
+	sort.Slice(output.TestGroups, func(i, j int) bool {
+		return output.TestGroups[i].Name < output.TestGroups[j].Name
+	})
+
+	return output, err
+}
+
+// RemoveTestgridDashboards removes the dashboards from the TestGrid config
+// file, along with their references in the dashboard groups. The groups left
+// without any dashboard are removed as well. Only the lines of the removed
+// entries are deleted, so that the rest of the file, including the comments,
+// is kept as it is.
+func RemoveTestgridDashboards(file string, dashboards []string) error {
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read TestGrid config %s: %v", file, err)
+	}
+	doc := yamlv3.Node{}
+	if err := yamlv3.Unmarshal(bs, &doc); err != nil {
+		return fmt.Errorf("failed to parse TestGrid config %s: %v", file, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	removed := sets.NewString(dashboards...)
+	lines := map[int]bool{}
+	remove := func(n *yamlv3.Node) {
+		for l := n.Line; l <= lastLine(n); l++ {
+			lines[l] = true
+		}
+	}
+	blockSequence := func(key string, n *yamlv3.Node) (*yamlv3.Node, error) {
+		s := mappingValue(n, key)
+		if s == nil || s.Kind != yamlv3.SequenceNode {
+			return nil, nil
+		}
+		if s.Style&yamlv3.FlowStyle != 0 {
+			return nil, fmt.Errorf("%s:%d: %s must not be in flow style", file, s.Line, key)
+		}
+		return s, nil
+	}
+
+	ds, err := blockSequence("dashboards", doc.Content[0])
+	if err != nil {
+		return err
+	}
+	if ds != nil {
+		for _, d := range ds.Content {
+			if name := mappingValue(d, "name"); name != nil && removed.Has(name.Value) {
+				remove(d)
+			}
+		}
+	}
+	groups, err := blockSequence("dashboard_groups", doc.Content[0])
+	if err != nil {
+		return err
+	}
+	if groups != nil {
+		for _, g := range groups.Content {
+			names, err := blockSequence("dashboard_names", g)
+			if err != nil {
+				return err
+			}
+			if names == nil || len(names.Content) == 0 {
+				continue
+			}
+			kept := 0
+			for _, n := range names.Content {
+				if removed.Has(n.Value) {
+					remove(n)
+				} else {
+					kept++
+				}
+			}
+			if kept == 0 {
+				remove(g)
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	res := make([]string, 0)
+	for i, line := range strings.SplitAfter(string(bs), "\n") {
+		if !lines[i+1] {
+			res = append(res, line)
+		}
+	}
+	return ioutil.WriteFile(file, []byte(strings.Join(res, "")), 0o644)
+}
+
+// lastLine returns the last line of the node and its children.
+func lastLine(n *yamlv3.Node) int {
+	res := n.Line
+	for _, c := range n.Content {
+		if l := lastLine(c); l > res {
+			res = l
+		}
+	}
+	return res
+}
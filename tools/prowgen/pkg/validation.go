@@ -0,0 +1,167 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// regex to match the line number in the YAML parsing errors.
+var yamlErrorLineRegex = regexp.MustCompile(`line (\d+)`)
+
+// ValidationError is a problem found in a meta config file. Line and Column are
+// the position of the job in the file, or of the problem itself for YAML
+// parsing errors, and are 0 if unknown.
+type ValidationError struct {
+	File    string `json:"file"`
+	Job     string `json:"job,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	var sb strings.Builder
+	sb.WriteString(e.File)
+	if e.Line != 0 {
+		fmt.Fprintf(&sb, ":%d", e.Line)
+		if e.Column != 0 {
+			fmt.Fprintf(&sb, ":%d", e.Column)
+		}
+	}
+	if e.Job != "" {
+		fmt.Fprintf(&sb, ": job %q", e.Job)
+	}
+	fmt.Fprintf(&sb, ": %s", e.Message)
+	return sb.String()
+}
+
+// ValidationErrors flattens the error returned by the functions of this
+// package into a list of validation errors. Errors that are not validation
+// errors are converted with only their message set.
+func ValidationErrors(err error) []*ValidationError {
+	if err == nil {
+		return nil
+	}
+	res := make([]*ValidationError, 0)
+	for _, e := range flattenErrors(err) {
+		var ve *ValidationError
+		if errors.As(e, &ve) {
+			res = append(res, ve)
+		} else {
+			res = append(res, &ValidationError{Message: e.Error()})
+		}
+	}
+	return res
+}
+
+// jobError creates a validation error for the job in the file. The job can be
+// empty if the problem is not specific to a job.
+func jobError(file, job, format string, args ...interface{}) *ValidationError {
+	line, column := locateJob(file, job)
+	return &ValidationError{
+		File:    file,
+		Job:     job,
+		Line:    line,
+		Column:  column,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// wrapJobErrors converts all the errors in err, which can be a multierror, into
+// validation errors for the job in the file.
+func wrapJobErrors(file, job string, err error) error {
+	var res error
+	for _, e := range flattenErrors(err) {
+		var ve *ValidationError
+		if errors.As(e, &ve) {
+			res = multierror.Append(res, ve)
+		} else {
+			res = multierror.Append(res, jobError(file, job, "%v", e))
+		}
+	}
+	return res
+}
+
+// yamlError creates a validation error for an error returned when parsing the
+// file, with the line of the problem if the error has one.
+func yamlError(file string, err error) *ValidationError {
+	ve := &ValidationError{File: file, Message: err.Error()}
+	if m := yamlErrorLineRegex.FindStringSubmatch(err.Error()); len(m) == 2 {
+		ve.Line, _ = strconv.Atoi(m[1])
+	}
+	return ve
+}
+
+func flattenErrors(err error) []error {
+	if err == nil {
+		return nil
+	}
+	var merr *multierror.Error
+	if errors.As(err, &merr) {
+		res := make([]error, 0)
+		for _, e := range merr.Errors {
+			res = append(res, flattenErrors(e)...)
+		}
+		return res
+	}
+	return []error{err}
+}
+
+// locateJob returns the line and column of the job with the given name in the
+// meta config file, or 0 if it cannot be found.
+func locateJob(file, job string) (int, int) {
+	if job == "" {
+		return 0, 0
+	}
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, 0
+	}
+	doc := yamlv3.Node{}
+	if err := yamlv3.Unmarshal(bs, &doc); err != nil || len(doc.Content) == 0 {
+		return 0, 0
+	}
+	jobs := mappingValue(doc.Content[0], "jobs")
+	if jobs == nil || jobs.Kind != yamlv3.SequenceNode {
+		return 0, 0
+	}
+	for _, j := range jobs.Content {
+		if name := mappingValue(j, "name"); name != nil && name.Value == job {
+			return j.Line, j.Column
+		}
+	}
+	return 0, 0
+}
+
+func mappingValue(node *yamlv3.Node, key string) *yamlv3.Node {
+	if node.Kind != yamlv3.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}